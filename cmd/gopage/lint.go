@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"github.com/hazyhaar/gopage/pkg/funcs"
+	"github.com/hazyhaar/gopage/pkg/lint"
+	"github.com/hazyhaar/gopage/pkg/render"
+	"zombiezen.com/go/sqlite"
+)
+
+// runLint implements gopage lint: it checks the SQL files against the
+// database and prints a file:line diagnostic per problem. It returns the
+// exit code, 1 if there are problems and 2 if the check could not run.
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("gopage lint", flag.ExitOnError)
+	var (
+		dbPath       = flags.String("db", "gopage.db", "SQLite database the statements are prepared against")
+		sqlDir       = flags.String("sql", "./sql", "SQL files directory")
+		templatesDir = flags.String("templates", "", "Directory of templates overriding or extending the embedded ones")
+	)
+	flags.Parse(args)
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelWarn,
+	}))
+
+	// Statements are prepared, never run: a read-only connection will do
+	conn, err := sqlite.OpenConn(*dbPath, sqlite.OpenReadOnly)
+	if err != nil {
+		logger.Error("failed to open database", "path", *dbPath, "error", err)
+		return 2
+	}
+	defer conn.Close()
+	if err := funcs.New().Apply(conn); err != nil {
+		logger.Error("failed to register SQL functions", "error", err)
+		return 2
+	}
+
+	templateFS, overrideFS, err := loadTemplates(*templatesDir)
+	if err != nil {
+		logger.Error("failed to load templates", "error", err)
+		return 2
+	}
+	renderer, err := render.New(render.Config{
+		TemplatesFS: templateFS,
+		Logger:      logger,
+		OverrideFS:  overrideFS,
+	})
+	if err != nil {
+		logger.Error("failed to create renderer", "error", err)
+		return 2
+	}
+	templateFSs := []fs.FS{templateFS}
+	if overrideFS != nil {
+		templateFSs = append(templateFSs, overrideFS)
+	}
+
+	diags, err := lint.Run(lint.Config{
+		SQLDir:    *sqlDir,
+		Conn:      conn,
+		Renderer:  renderer,
+		Templates: templateFSs,
+	})
+	if err != nil {
+		logger.Error("lint failed", "sql_dir", *sqlDir, "error", err)
+		return 2
+	}
+
+	for _, d := range diags {
+		fmt.Println(d)
+	}
+	if len(diags) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s)\n", len(diags))
+		return 1
+	}
+	return 0
+}
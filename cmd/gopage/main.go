@@ -4,26 +4,45 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"io/fs"
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 
 	"github.com/hazyhaar/gopage/internal/templates"
 	"github.com/hazyhaar/gopage/pkg/db"
 	"github.com/hazyhaar/gopage/pkg/funcs"
+	"github.com/hazyhaar/gopage/pkg/migrate"
 	"github.com/hazyhaar/gopage/pkg/render"
 	"github.com/hazyhaar/gopage/pkg/server"
 )
 
 func main() {
+	// gopage lint checks the SQL files instead of serving them
+	if len(os.Args) > 1 && os.Args[1] == "lint" {
+		os.Exit(runLint(os.Args[2:]))
+	}
+
 	// Parse flags
 	var (
 		dbPath = flag.String("db", "gopage.db", "SQLite database path")
 		sqlDir = flag.String("sql", "./sql", "SQL files directory")
 		port   = flag.String("port", "8080", "HTTP port")
 		debug  = flag.Bool("debug", false, "Enable debug logging")
+
+		uploadDir = flag.String("uploads", "", "Directory for uploaded files, served at /uploads/ (disabled if empty)")
+		maxUpload = flag.Int64("max-upload", server.DefaultMaxUploadSize, "Maximum multipart request size in bytes")
+
+		queryTimeout = flag.Duration("query-timeout", server.DefaultQueryTimeout, "Default page execution time limit, overridden by -- @page timeout=, downloads excepted (negative for none)")
+
+		migrationsDir = flag.String("migrations", "", "Migrations directory (default <sql>/_migrations)")
+		migrateOnly   = flag.Bool("migrate", false, "Apply pending migrations and exit")
+		seedFile      = flag.String("seed", "", "SQL script run after migrations, e.g. sql/_seed/demo.sql for demo data (disabled if empty)")
+
+		templatesDir = flag.String("templates", "", "Directory of templates overriding or extending the embedded ones")
 	)
 	flag.Parse()
 
@@ -58,8 +77,35 @@ func main() {
 	}
 	logger.Info("registered custom SQL functions")
 
-	// Load templates
-	templateFS, err := fs.Sub(templates.FS, "files")
+	// Apply schema migrations on the writer before serving
+	if *migrationsDir == "" {
+		*migrationsDir = filepath.Join(*sqlDir, "_migrations")
+	}
+	if err := runMigrations(database, *migrationsDir, logger); err != nil {
+		logger.Error("migrations failed", "dir", *migrationsDir, "error", err)
+		os.Exit(1)
+	}
+	if *seedFile != "" {
+		if err := runSeed(database, *seedFile); err != nil {
+			logger.Error("seed failed", "path", *seedFile, "error", err)
+			os.Exit(1)
+		}
+		logger.Info("applied seed", "path", *seedFile)
+	}
+	if *migrateOnly {
+		return
+	}
+
+	if *uploadDir != "" {
+		if err := os.MkdirAll(*uploadDir, 0o755); err != nil {
+			logger.Error("failed to create upload directory", "path", *uploadDir, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Load templates: custom ones (layouts/, components/, system/)
+	// override the embedded ones
+	templateFS, overrideFS, err := loadTemplates(*templatesDir)
 	if err != nil {
 		logger.Error("failed to load templates", "error", err)
 		os.Exit(1)
@@ -69,6 +115,7 @@ func main() {
 	renderer, err := render.New(render.Config{
 		TemplatesFS: templateFS,
 		Logger:      logger,
+		OverrideFS:  overrideFS,
 	})
 	if err != nil {
 		logger.Error("failed to create renderer", "error", err)
@@ -81,6 +128,10 @@ func main() {
 		Renderer: renderer,
 		SQLDir:   *sqlDir,
 		Logger:   logger,
+
+		UploadDir:     *uploadDir,
+		MaxUploadSize: *maxUpload,
+		QueryTimeout:  *queryTimeout,
 	})
 
 	// Handle shutdown
@@ -109,3 +160,53 @@ func main() {
 	<-ctx.Done()
 	logger.Info("goodbye!")
 }
+
+// runMigrations applies the pending migrations of dir on the writer.
+func runMigrations(database *db.DB, dir string, logger *slog.Logger) error {
+	migrations, err := migrate.Load(dir)
+	if err != nil {
+		return err
+	}
+
+	conn, release, err := database.Writer(context.Background())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	applied, err := migrate.Apply(conn, migrations)
+	for _, m := range applied {
+		logger.Info("applied migration", "version", m.Version, "name", m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("database schema up to date", "migrations", len(migrations), "applied", len(applied))
+	return nil
+}
+
+// runSeed runs the seed script at path on the writer.
+func runSeed(database *db.DB, path string) error {
+	conn, release, err := database.Writer(context.Background())
+	if err != nil {
+		return err
+	}
+	defer release()
+	return migrate.Seed(conn, path)
+}
+
+// loadTemplates returns the embedded templates, and those of templatesDir
+// overriding or extending them (nil if templatesDir is empty).
+func loadTemplates(templatesDir string) (templateFS, overrideFS fs.FS, err error) {
+	templateFS, err = fs.Sub(templates.FS, "files")
+	if err != nil {
+		return nil, nil, err
+	}
+	if templatesDir != "" {
+		if info, err := os.Stat(templatesDir); err != nil || !info.IsDir() {
+			return nil, nil, fmt.Errorf("invalid templates directory %s", templatesDir)
+		}
+		overrideFS = os.DirFS(templatesDir)
+	}
+	return templateFS, overrideFS, nil
+}
@@ -20,12 +20,16 @@ type ConnInitFunc func(*sqlite.Conn) error
 type DB struct {
 	path string
 
-	// readerPool for concurrent reads
+	// readerPool for concurrent reads, and readerSem holding a token per
+	// reader taken, so that TryReader can tell whether one is free
 	readerPool *sqlitex.Pool
+	readerSem  chan struct{}
+	tryMu      sync.Mutex
 
-	// writerConn is a single connection for writes (SQLite limitation)
+	// writerConn is a single connection for writes (SQLite limitation),
+	// held by whoever holds the writer semaphore
 	writerConn *sqlite.Conn
-	writerMu   sync.Mutex
+	writerSem  chan struct{}
 
 	// connInit is called to initialize each connection (e.g., register functions)
 	connInit ConnInitFunc
@@ -46,19 +50,10 @@ func Open(cfg Config) (*DB, error) {
 		cfg.ReaderCount = 4
 	}
 
-	// Open reader pool
-	readerPool, err := sqlitex.NewPool(cfg.Path, sqlitex.PoolOptions{
-		Flags:    sqlite.OpenReadOnly | sqlite.OpenWAL,
-		PoolSize: cfg.ReaderCount,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("open reader pool: %w", err)
-	}
-
-	// Open single writer connection
+	// Open single writer connection first: it creates the database file,
+	// which read-only connections can't, so a fresh database can be migrated
 	writerConn, err := sqlite.OpenConn(cfg.Path, sqlite.OpenReadWrite|sqlite.OpenCreate|sqlite.OpenWAL)
 	if err != nil {
-		readerPool.Close()
 		return nil, fmt.Errorf("open writer conn: %w", err)
 	}
 
@@ -66,22 +61,67 @@ func Open(cfg Config) (*DB, error) {
 	err = sqlitex.ExecuteTransient(writerConn, "PRAGMA journal_mode=WAL;", nil)
 	if err != nil {
 		writerConn.Close()
-		readerPool.Close()
 		return nil, fmt.Errorf("enable WAL: %w", err)
 	}
 
+	// Open reader pool
+	readerPool, err := sqlitex.NewPool(cfg.Path, sqlitex.PoolOptions{
+		Flags:    sqlite.OpenReadOnly | sqlite.OpenWAL,
+		PoolSize: cfg.ReaderCount,
+	})
+	if err != nil {
+		writerConn.Close()
+		return nil, fmt.Errorf("open reader pool: %w", err)
+	}
+
 	return &DB{
 		path:       cfg.Path,
 		readerPool: readerPool,
+		readerSem:  make(chan struct{}, cfg.ReaderCount),
 		writerConn: writerConn,
+		writerSem:  make(chan struct{}, 1),
 	}, nil
 }
 
 // Reader gets a read-only connection from the pool.
 // The returned function must be called to release the connection.
 func (db *DB) Reader(ctx context.Context) (*sqlite.Conn, func(), error) {
+	select {
+	case db.readerSem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, fmt.Errorf("take reader: %w", ctx.Err())
+	}
+	return db.takeReader(ctx)
+}
+
+// TryReader gets a read-only connection like Reader if one is free right
+// away, and reports false otherwise. A request holding a reader can thus
+// use more of them without waiting, while one is left free so that new
+// requests don't wait for those extra readers either.
+func (db *DB) TryReader(ctx context.Context) (*sqlite.Conn, func(), bool) {
+	db.tryMu.Lock()
+	ok := len(db.readerSem) < cap(db.readerSem)-1
+	if ok {
+		db.readerSem <- struct{}{}
+	}
+	db.tryMu.Unlock()
+	if !ok {
+		return nil, nil, false
+	}
+
+	conn, release, err := db.takeReader(ctx)
+	if err != nil {
+		return nil, nil, false
+	}
+	return conn, release, true
+}
+
+// takeReader takes a connection from the pool for the caller holding a
+// reader token, which is released with the connection.
+func (db *DB) takeReader(ctx context.Context) (*sqlite.Conn, func(), error) {
 	conn, err := db.readerPool.Take(ctx)
 	if err != nil {
+		<-db.readerSem
 		return nil, nil, fmt.Errorf("take reader: %w", err)
 	}
 
@@ -90,26 +130,45 @@ func (db *DB) Reader(ctx context.Context) (*sqlite.Conn, func(), error) {
 		if _, initialized := db.initializedConns.Load(conn); !initialized {
 			if err := db.connInit(conn); err != nil {
 				db.readerPool.Put(conn)
+				<-db.readerSem
 				return nil, nil, fmt.Errorf("init reader conn: %w", err)
 			}
 			db.initializedConns.Store(conn, true)
 		}
 	}
 
-	return conn, func() { db.readerPool.Put(conn) }, nil
+	return conn, func() {
+		db.readerPool.Put(conn)
+		<-db.readerSem
+	}, nil
 }
 
-// Writer gets exclusive access to the writer connection.
-// The returned function must be called to release the lock.
+// Writer gets exclusive access to the writer connection, waiting for it
+// until ctx is done. Like readers, the connection is interrupted when ctx
+// is done. The returned function must be called to release it; a
+// transaction left open is rolled back.
 func (db *DB) Writer(ctx context.Context) (*sqlite.Conn, func(), error) {
-	db.writerMu.Lock()
-	return db.writerConn, func() { db.writerMu.Unlock() }, nil
+	select {
+	case db.writerSem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, fmt.Errorf("take writer: %w", ctx.Err())
+	}
+
+	conn := db.writerConn
+	conn.SetInterrupt(ctx.Done())
+	return conn, func() {
+		conn.SetInterrupt(nil)
+		if !conn.AutocommitEnabled() {
+			sqlitex.ExecuteTransient(conn, "ROLLBACK", nil)
+		}
+		<-db.writerSem
+	}, nil
 }
 
 // Close closes all connections.
 func (db *DB) Close() error {
-	db.writerMu.Lock()
-	defer db.writerMu.Unlock()
+	db.writerSem <- struct{}{}
+	defer func() { <-db.writerSem }()
 
 	var errs []error
 	if err := db.writerConn.Close(); err != nil {
@@ -130,7 +189,7 @@ func (db *DB) Path() string {
 }
 
 // WriterConn returns the raw writer connection for function registration.
-// Use with caution - caller must hold the writer lock.
+// Use with caution - caller must hold the writer (see Writer).
 func (db *DB) WriterConn() *sqlite.Conn {
 	return db.writerConn
 }
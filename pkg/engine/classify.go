@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// writeOps are the authorizer actions that modify the database.
+// PRAGMA statements are treated as reads, as they were by the old
+// SELECT/WITH/PRAGMA prefix check.
+var writeOps = map[sqlite.OpType]bool{
+	sqlite.OpInsert:            true,
+	sqlite.OpUpdate:            true,
+	sqlite.OpDelete:            true,
+	sqlite.OpCreateIndex:       true,
+	sqlite.OpCreateTable:       true,
+	sqlite.OpCreateTrigger:     true,
+	sqlite.OpCreateView:        true,
+	sqlite.OpCreateTempIndex:   true,
+	sqlite.OpCreateTempTable:   true,
+	sqlite.OpCreateTempTrigger: true,
+	sqlite.OpCreateTempView:    true,
+	sqlite.OpCreateVTable:      true,
+	sqlite.OpDropIndex:         true,
+	sqlite.OpDropTable:         true,
+	sqlite.OpDropTrigger:       true,
+	sqlite.OpDropView:          true,
+	sqlite.OpDropTempIndex:     true,
+	sqlite.OpDropTempTable:     true,
+	sqlite.OpDropTempTrigger:   true,
+	sqlite.OpDropTempView:      true,
+	sqlite.OpDropVTable:        true,
+	sqlite.OpAlterTable:        true,
+	sqlite.OpReindex:           true,
+	sqlite.OpAnalyze:           true,
+	sqlite.OpAttach:            true,
+	sqlite.OpDetach:            true,
+	sqlite.OpTransaction:       true,
+	sqlite.OpSavepoint:         true,
+}
+
+// Classify marks each query of file as read-only or writing by preparing
+// it on conn with an authorizer that records write actions. A statement
+// that fails to prepare (e.g. while a migration adds its table) fails the
+// classification, leaving file unclassified, rather than being taken for
+// a write for good.
+//
+// Classify fails if the file declares "-- @page readonly" and a query writes.
+// Any connection works, including a read-only one: statements are prepared
+// but never stepped.
+func (e *Executor) Classify(conn *sqlite.Conn, file *File) error {
+	for i := range file.Queries {
+		// @else and @end have no statement
+		if file.Queries[i].SQL == "" {
+			file.Queries[i].ReadOnly = true
+			continue
+		}
+		readOnly, err := classifyQuery(conn, file.Queries[i].SQL)
+		if err != nil {
+			q := file.Queries[i]
+			return fmt.Errorf("%s: query %q: %w", q.Location(), q.Component, err)
+		}
+		file.Queries[i].ReadOnly = readOnly
+	}
+	file.classified = true
+
+	if file.Options["readonly"] == "true" {
+		for _, q := range file.Queries {
+			if !q.ReadOnly {
+				return fmt.Errorf("%s: page is declared readonly but query %q writes", q.Location(), q.Component)
+			}
+		}
+	}
+	return nil
+}
+
+// classifyQuery reports whether sql only reads from the database.
+func classifyQuery(conn *sqlite.Conn, sql string) (bool, error) {
+	writes := false
+	auth := sqlite.AuthorizeFunc(func(action sqlite.Action) sqlite.AuthResult {
+		if writeOps[action.Type()] {
+			writes = true
+		}
+		return sqlite.AuthResultOK
+	})
+	if err := conn.SetAuthorizer(auth); err != nil {
+		return false, err
+	}
+	defer conn.SetAuthorizer(nil)
+
+	stmt, _, err := conn.PrepareTransient(normalizeParams(sql))
+	if err != nil {
+		return false, err
+	}
+	stmt.Finalize()
+
+	return !writes, nil
+}
+
+// Statement describes the statement of a query, prepared but not run.
+type Statement struct {
+	// Params are the names of the params it references, without prefix
+	Params []string
+
+	// Columns are the names of its result columns
+	Columns []string
+
+	// Trailing is the SQL following its first statement, which never runs
+	Trailing string
+}
+
+// sqlCommentRegex matches SQL comments.
+var sqlCommentRegex = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+
+// Describe prepares query on conn to describe its statement, for static
+// checks of SQL files. Like Classify, it never steps the statement.
+func Describe(conn *sqlite.Conn, query Query) (*Statement, error) {
+	sql := normalizeParams(query.SQL)
+	stmt, trailing, err := conn.PrepareTransient(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Finalize()
+
+	st := &Statement{}
+	for i := 1; i <= stmt.BindParamCount(); i++ {
+		// Positional ? params have no name
+		if name := stmt.BindParamName(i); name != "" {
+			st.Params = append(st.Params, name[1:])
+		}
+	}
+	for i := 0; i < stmt.ColumnCount(); i++ {
+		st.Columns = append(st.Columns, stmt.ColumnName(i))
+	}
+
+	// Comments and semicolons after the statement are no statement.
+	// Normalizing params keeps the length of the SQL.
+	tail := query.SQL[len(query.SQL)-trailing:]
+	if strings.Trim(sqlCommentRegex.ReplaceAllString(tail, ""), " \t\r\n;") != "" {
+		st.Trailing = strings.TrimSpace(tail)
+	}
+	return st, nil
+}
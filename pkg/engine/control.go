@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// fileRun holds the state of an ExecuteFile call. Its params grow as
+// authenticate, set and each queries bind variables for the next queries.
+type fileRun struct {
+	executor *Executor
+	ctx      context.Context
+	conn     *sqlite.Conn
+	params   Params
+	types    map[string]string
+
+	// emit receives each result as soon as its query ran
+	emit func(*Result) error
+
+	// export selects the queries to stream instead, to writer once started
+	export   *Export
+	writer   RowWriter
+	exported bool
+}
+
+// block is an open @if block.
+type block struct {
+	// enclosing is set when the block containing the @if runs
+	enclosing bool
+
+	// active is set while the current branch runs
+	active bool
+
+	// taken is set once a branch has been chosen
+	taken bool
+}
+
+// queries runs queries in order, following @if/@else/@end blocks and
+// repeating the query after an @each once per row of its driving query.
+func (r *fileRun) queries(queries []Query) error {
+	var blocks []block
+	active := func() bool {
+		return len(blocks) == 0 || blocks[len(blocks)-1].active
+	}
+
+	// Consecutive independent read queries are run together, in parallel
+	// when possible
+	var batch []Query
+	for i := 0; i < len(queries); i++ {
+		query := queries[i]
+		if active() && r.independent(query) {
+			batch = append(batch, query)
+			continue
+		}
+		if err := r.batch(batch); err != nil {
+			return err
+		}
+		batch = batch[:0]
+
+		switch query.Component {
+		case "if":
+			b := block{enclosing: active()}
+			if b.enclosing {
+				ok, err := r.condition(query)
+				if err != nil {
+					return err
+				}
+				b.active, b.taken = ok, ok
+			}
+			blocks = append(blocks, b)
+
+		case "else":
+			if n := len(blocks); n > 0 {
+				b := &blocks[n-1]
+				b.active = b.enclosing && !b.taken
+				b.taken = true
+			}
+
+		case "end":
+			if n := len(blocks); n > 0 {
+				blocks = blocks[:n-1]
+			}
+
+		case "each":
+			// The repeated query is consumed here, whether or not it runs
+			i++
+			if active() && i < len(queries) {
+				if err := r.each(query, queries[i]); err != nil {
+					return err
+				}
+				if r.exported && !r.export.all {
+					return errExported
+				}
+			}
+
+		default:
+			if active() && r.exports(query) {
+				if err := r.stream(query); err != nil {
+					return err
+				}
+				if !r.export.all {
+					return errExported
+				}
+			} else if active() {
+				if err := r.query(query); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return r.batch(batch)
+}
+
+// query runs a single query and binds the variables it defines.
+func (r *fileRun) query(query Query) error {
+	result, err := r.executor.execute(r.ctx, r.conn, query, r.params, r.types)
+	return r.done(query, result, err)
+}
+
+// done binds the variables defined by the result of query, then emits it.
+func (r *fileRun) done(query Query, result *Result, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s: query %q: %w", query.Location(), query.Component, err)
+	}
+
+	switch query.Component {
+	case "authenticate":
+		// An authenticate query guards the rest of the file: no row means
+		// access is denied, otherwise its columns become $_user_* params
+		if len(result.Rows) == 0 {
+			return &AuthError{Query: query}
+		}
+		r.params, r.types = bindVariables(r.params, r.types, result)
+
+	case "set":
+		// A set query computes params once for the following queries
+		r.params, r.types = bindVariables(r.params, r.types, result)
+	}
+	return r.emit(result)
+}
+
+// condition evaluates the expression of an @if query.
+func (r *fileRun) condition(query Query) (bool, error) {
+	result, err := r.executor.execute(r.ctx, r.conn, query, r.params, r.types)
+	if err != nil {
+		return false, fmt.Errorf("%s: @if %s: %w", query.Location(), query.Options["expr"], err)
+	}
+	if len(result.Rows) == 0 || len(result.Columns) == 0 {
+		return false, nil
+	}
+	return result.Rows[0][result.Columns[0]] == int64(1), nil
+}
+
+// each runs the driving query of an @each, then query once per row with
+// the row's columns bound like those of a set query (streaming its rows if
+// it is exported).
+func (r *fileRun) each(driver, query Query) error {
+	result, err := r.executor.execute(r.ctx, r.conn, driver, r.params, r.types)
+	if err != nil {
+		return fmt.Errorf("%s: @each: %w", driver.Location(), err)
+	}
+
+	params, types := r.params, r.types
+	defer func() {
+		r.params, r.types = params, types
+	}()
+
+	run := r.query
+	if r.exports(query) {
+		run = r.stream
+	}
+	for _, row := range result.Rows {
+		r.params, r.types = bindVariables(params, types, &Result{
+			Query:   driver,
+			Columns: result.Columns,
+			Rows:    []map[string]interface{}{row},
+		})
+		if err := run(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package engine
+
+// DynamicComponent is the component of queries whose rows choose their
+// own components: -- @query component=dynamic
+const DynamicComponent = "dynamic"
+
+// Expand replaces the result of each dynamic query by one result per
+// component it switches to. A row with a non-NULL component column starts
+// a new component, its other non-NULL columns becoming the component's
+// options; the following rows are its items, with their NULL columns
+// dropped. A component row without items is its own item, so that e.g.
+// an alert reads its title and message from it. Rows before the first
+// component row are rendered as text.
+func Expand(results []*Result) []*Result {
+	var out []*Result
+	for _, result := range results {
+		if result.Query.Component != DynamicComponent {
+			out = append(out, result)
+			continue
+		}
+		out = append(out, split(result)...)
+	}
+	return out
+}
+
+// split expands the result of a dynamic query.
+func split(result *Result) []*Result {
+	var out []*Result
+	var current *Result
+
+	// configs are the component rows of out, for components without items
+	var configs []map[string]interface{}
+	start := func(component string, options map[string]string) {
+		query := result.Query
+		query.Component = component
+		query.Options = make(map[string]string, len(result.Query.Options)+len(options))
+		for k, v := range result.Query.Options {
+			if k != "component" {
+				query.Options[k] = v
+			}
+		}
+		for k, v := range options {
+			query.Options[k] = v
+		}
+		current = &Result{Query: query}
+		out = append(out, current)
+	}
+
+	for _, row := range result.Rows {
+		if component, ok := row["component"]; ok && component != nil {
+			options := make(map[string]string)
+			for _, col := range result.Columns {
+				if v := row[col]; col != "component" && v != nil {
+					options[col] = formatValue(v)
+				}
+			}
+			start(formatValue(component), options)
+			configs = append(configs, item(row))
+			continue
+		}
+		if current == nil {
+			start("text", nil)
+			configs = append(configs, nil)
+		}
+		current.Rows = append(current.Rows, item(row))
+	}
+
+	for i, r := range out {
+		if len(r.Rows) == 0 && len(configs[i]) > 0 {
+			r.Rows = []map[string]interface{}{configs[i]}
+		}
+	}
+
+	// Each component only lists the columns its items use
+	for _, r := range out {
+		for _, col := range result.Columns {
+			for _, row := range r.Rows {
+				if _, ok := row[col]; ok {
+					r.Columns = append(r.Columns, col)
+					break
+				}
+			}
+		}
+	}
+	return out
+}
+
+// item returns the non-NULL columns of a dynamic row, but its component.
+func item(row map[string]interface{}) map[string]interface{} {
+	item := make(map[string]interface{}, len(row))
+	for col, v := range row {
+		if col != "component" && v != nil {
+			item[col] = v
+		}
+	}
+	return item
+}
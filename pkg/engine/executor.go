@@ -2,9 +2,12 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 
 	"zombiezen.com/go/sqlite"
 )
@@ -25,7 +28,24 @@ type Result struct {
 }
 
 // Executor executes SQL queries with parameter binding.
-type Executor struct{}
+type Executor struct {
+	// Readers, when set, provides extra read-only connections to run the
+	// independent read-only queries of a file in parallel
+	Readers ReaderFunc
+
+	// StatementCacheSize bounds the statements kept prepared per connection
+	// (DefaultStatementCacheSize if zero); negative disables the cache, so
+	// that every statement is prepared for a single use
+	StatementCacheSize int
+
+	stmtsMu sync.Mutex
+	stmts   map[*sqlite.Conn]*stmtCache
+}
+
+// ReaderFunc returns a read-only connection and the function releasing
+// it, if one is free without waiting. Its statements are interrupted when
+// ctx is done.
+type ReaderFunc func(ctx context.Context) (conn *sqlite.Conn, release func(), ok bool)
 
 // NewExecutor creates a new query executor.
 func NewExecutor() *Executor {
@@ -36,86 +56,336 @@ func NewExecutor() *Executor {
 type Params map[string]string
 
 // Execute runs a query and returns results.
+// All parameters are bound as text; use ExecuteFile to honor @param types.
 func (e *Executor) Execute(ctx context.Context, conn *sqlite.Conn, query Query, params Params) (*Result, error) {
+	readOnly, err := classifyQuery(conn, query.SQL)
+	if err != nil {
+		return nil, fmt.Errorf("prepare: %w", err)
+	}
+	query.ReadOnly = readOnly
+	return e.execute(ctx, conn, query, params, nil)
+}
+
+// execute runs a query, binding declared parameters with their SQLite type.
+func (e *Executor) execute(ctx context.Context, conn *sqlite.Conn, query Query, params Params, types map[string]string) (*Result, error) {
 	result := &Result{
 		Query:   query,
 		Rows:    []map[string]interface{}{},
 		Columns: []string{},
 	}
 
-	// Normalize parameter syntax ($param -> :param for binding)
-	sql := normalizeParams(query.SQL)
-
-	// Prepare statement
-	stmt, _, err := conn.PrepareTransient(sql)
+	stmt, release, err := e.prepare(conn, query, params, types)
 	if err != nil {
-		return nil, fmt.Errorf("prepare: %w", err)
+		return nil, err
 	}
-	defer stmt.Finalize()
+	defer release()
 
-	// Bind parameters
-	if err := bindParams(stmt, params); err != nil {
-		return nil, fmt.Errorf("bind: %w", err)
+	// Get column names; statements without columns (plain
+	// INSERT/UPDATE/DELETE) still step once, and INSERT ... RETURNING
+	// yields rows like a SELECT
+	colCount := stmt.ColumnCount()
+	for i := 0; i < colCount; i++ {
+		result.Columns = append(result.Columns, stmt.ColumnName(i))
 	}
 
-	// Check if this is a SELECT query
-	if isSelectQuery(query.SQL) {
-		// Get column names
-		colCount := stmt.ColumnCount()
+	// Read rows
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, fmt.Errorf("step: %w", err)
+		}
+		if !hasRow {
+			break
+		}
+
+		row := make(map[string]interface{})
 		for i := 0; i < colCount; i++ {
-			result.Columns = append(result.Columns, stmt.ColumnName(i))
+			colName := result.Columns[i]
+			row[colName] = getColumnValue(stmt, i)
 		}
+		result.Rows = append(result.Rows, row)
+	}
 
-		// Read rows
-		for {
-			hasRow, err := stmt.Step()
-			if err != nil {
-				return nil, fmt.Errorf("step: %w", err)
-			}
-			if !hasRow {
-				break
-			}
+	if !query.ReadOnly {
+		result.RowsAffected = int64(conn.Changes())
+	}
 
-			row := make(map[string]interface{})
-			for i := 0; i < colCount; i++ {
-				colName := result.Columns[i]
-				row[colName] = getColumnValue(stmt, i)
-			}
-			result.Rows = append(result.Rows, row)
+	return result, nil
+}
+
+// prepare prepares query on conn and binds params to it. Statements are
+// cached by the connection and reused by later requests, up to the
+// executor's cache size; release resets the statement for its next use.
+// A query followed by other statements, which conn.Prepare refuses, gets a
+// transient statement instead.
+func (e *Executor) prepare(conn *sqlite.Conn, query Query, params Params, types map[string]string) (stmt *sqlite.Stmt, release func(), err error) {
+	if len(query.Overrides) > 0 {
+		params, types = override(params, types, query.Overrides)
+	}
+
+	// Normalize parameter syntax ($param -> :param for binding)
+	sql := normalizeParams(query.SQL)
+
+	// Prepare statement
+	err = errNoCache
+	if e.StatementCacheSize >= 0 {
+		stmt, err = conn.Prepare(sql)
+	}
+	if err == nil {
+		e.cached(conn, stmt)
+		release = func() {
+			stmt.Reset()
+			stmt.ClearBindings()
 		}
 	} else {
-		// Execute non-SELECT query (INSERT/UPDATE/DELETE)
-		_, err := stmt.Step()
+		stmt, _, err = conn.PrepareTransient(sql)
 		if err != nil {
-			return nil, fmt.Errorf("exec: %w", err)
+			return nil, nil, fmt.Errorf("prepare: %w", err)
 		}
-		result.RowsAffected = int64(conn.Changes())
+		release = func() { stmt.Finalize() }
 	}
 
-	return result, nil
+	// Bind parameters
+	if err := bindParams(stmt, params, types); err != nil {
+		release()
+		return nil, nil, fmt.Errorf("bind: %w", err)
+	}
+	return stmt, release, nil
 }
 
 // ExecuteFile executes all queries in a file and returns results.
+// Parameters are validated against the file's @param declarations first;
+// a *ValidationError is returned without running any query if they don't match.
+// Control flow queries (@if, @else, @end, @each) decide which queries run
+// and don't produce results of their own.
+// When ctx is done (request canceled, page timeout), the running statement
+// is interrupted and an error returned.
 func (e *Executor) ExecuteFile(ctx context.Context, conn *sqlite.Conn, file *File, params Params) ([]*Result, error) {
 	var results []*Result
-	for _, query := range file.Queries {
-		result, err := e.Execute(ctx, conn, query, params)
-		if err != nil {
-			return results, fmt.Errorf("query %q: %w", query.Component, err)
-		}
+	err := e.ExecuteFileFunc(ctx, conn, file, params, func(result *Result) error {
 		results = append(results, result)
+		return nil
+	})
+	return results, err
+}
+
+// ExecuteFileFunc executes a file like ExecuteFile, but passes each result
+// to fn as soon as its query ran instead of collecting them, so that a
+// caller can render it before the next query runs. An error returned by fn
+// stops the file and is returned.
+func (e *Executor) ExecuteFileFunc(ctx context.Context, conn *sqlite.Conn, file *File, params Params, fn func(*Result) error) error {
+	_, err := e.run(ctx, conn, file, params, fn, nil)
+	return err
+}
+
+// run executes file, streaming the queries selected by export if any. It
+// reports whether one of them ran.
+func (e *Executor) run(ctx context.Context, conn *sqlite.Conn, file *File, params Params, fn func(*Result) error, export *Export) (exported bool, err error) {
+	params, err = ValidateParams(file.Params, params)
+	if err != nil {
+		return false, err
+	}
+	if !file.classified {
+		if err := e.Classify(conn, file); err != nil {
+			return false, err
+		}
+	}
+
+	// Interrupt running statements when ctx is done, until it returns
+	defer conn.SetInterrupt(conn.SetInterrupt(ctx.Done()))
+
+	run := &fileRun{
+		executor: e,
+		ctx:      ctx,
+		conn:     conn,
+		params:   params,
+		types:    paramTypes(file.Params),
+		emit:     fn,
+		export:   export,
+	}
+	err = run.queries(file.Queries)
+	if errors.Is(err, errExported) {
+		err = run.writer.Close()
+	}
+	return run.exported, err
+}
+
+// AuthError is returned by ExecuteFile when an authenticate query
+// returns no row. The query options tell the server how to deny access
+// (redirect target, status code).
+type AuthError struct {
+	Query Query
+}
+
+func (e *AuthError) Error() string {
+	return "authentication required"
+}
+
+// WithVariables returns a copy of params with the variables produced by the
+// authenticate and set queries among results, so that a caller running
+// several files in sequence (e.g. guards, then a page) can pass them on.
+func WithVariables(params Params, results []*Result) Params {
+	for _, result := range results {
+		params = params.with(variables(result))
+	}
+	return params
+}
+
+// variables returns the params defined by an authenticate or set result,
+// from its first row. NULL values, and all columns when there is no row,
+// map to nil.
+//
+// An authenticate query defines $_user_<column>. A set (or each) query defines
+// $<column> without a name, $<name> with a name and a single column, and
+// $<name>_<column> with a name and several columns.
+func variables(result *Result) map[string]interface{} {
+	var row map[string]interface{}
+	if len(result.Rows) > 0 {
+		row = result.Rows[0]
+	}
+
+	vars := make(map[string]interface{}, len(result.Columns))
+	for _, col := range result.Columns {
+		if name := variableName(result.Query, result.Columns, col); name != "" {
+			vars[name] = row[col]
+		}
+	}
+	return vars
+}
+
+// Variables returns the names of the params a query defines for the
+// following ones, given its result columns: none unless it is an
+// authenticate, set or each query.
+func Variables(query Query, columns []string) []string {
+	var names []string
+	for _, col := range columns {
+		if name := variableName(query, columns, col); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// variableName returns the param a result column of query defines, or
+// "" if it defines none.
+func variableName(query Query, columns []string, col string) string {
+	switch query.Component {
+	case "authenticate":
+		return "_user_" + col
+	case "set", "each":
+		name := query.Options["name"]
+		switch {
+		case name == "":
+			return col
+		case len(columns) == 1:
+			return name
+		default:
+			return name + "_" + col
+		}
 	}
-	return results, nil
+	return ""
 }
 
+// bindVariables adds the variables of result to params and records their
+// SQLite type in types, so that a computed count is bound as an INTEGER
+// rather than as text.
+func bindVariables(params Params, types map[string]string, result *Result) (Params, map[string]string) {
+	vars := variables(result)
+	out := make(map[string]string, len(types)+len(vars))
+	for k, v := range types {
+		out[k] = v
+	}
+	for name, value := range vars {
+		switch value.(type) {
+		case int64:
+			out[name] = ParamInt
+		case float64:
+			out[name] = ParamFloat
+		case []byte:
+			out[name] = ParamFile // bound as a BLOB
+		default:
+			delete(out, name)
+		}
+	}
+	return params.with(vars), out
+}
+
+// override applies the params of an -- @include to the queries it inlined.
+// Literal values are bound as text; $name values copy another param.
+func override(params Params, types map[string]string, overrides map[string]string) (Params, map[string]string) {
+	outParams := make(Params, len(params)+len(overrides))
+	for k, v := range params {
+		outParams[k] = v
+	}
+	outTypes := make(map[string]string, len(types))
+	for k, v := range types {
+		outTypes[k] = v
+	}
+
+	for name, value := range overrides {
+		delete(outTypes, name)
+		if !strings.HasPrefix(value, "$") {
+			outParams[name] = value
+			continue
+		}
+		ref := value[1:]
+		if v, ok := params[ref]; ok {
+			outParams[name] = v
+			if t, ok := types[ref]; ok {
+				outTypes[name] = t
+			}
+		} else {
+			delete(outParams, name)
+		}
+	}
+	return outParams, outTypes
+}
+
+// with returns a copy of p with vars applied. Variables set to nil are
+// removed, so that they are bound as NULL instead of being taken from
+// the request.
+func (p Params) with(vars map[string]interface{}) Params {
+	out := make(Params, len(p)+len(vars))
+	for k, v := range p {
+		out[k] = v
+	}
+	for k, v := range vars {
+		if v == nil {
+			delete(out, k)
+		} else {
+			out[k] = formatValue(v)
+		}
+	}
+	return out
+}
+
+// formatValue converts a column value to its parameter text form.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// dollarParamRegex matches $param placeholders.
+var dollarParamRegex = regexp.MustCompile(`\$(\w+)`)
+
 // normalizeParams converts $param to :param syntax.
 func normalizeParams(sql string) string {
-	re := regexp.MustCompile(`\$(\w+)`)
-	return re.ReplaceAllString(sql, ":$1")
+	return dollarParamRegex.ReplaceAllString(sql, ":$1")
 }
 
 // bindParams binds parameters to a prepared statement.
-func bindParams(stmt *sqlite.Stmt, params Params) error {
+// Parameters with a declared type are bound as INTEGER/REAL/BLOB; others as TEXT.
+func bindParams(stmt *sqlite.Stmt, params Params, types map[string]string) error {
 	// Build a map of parameter names to indices
 	paramIndices := make(map[string]int)
 	for i := 1; i <= stmt.BindParamCount(); i++ {
@@ -127,27 +397,37 @@ func bindParams(stmt *sqlite.Stmt, params Params) error {
 
 	// Bind each provided parameter
 	for name, value := range params {
-		// Try :name format
-		if idx, ok := paramIndices[":"+name]; ok {
-			stmt.BindText(idx, value)
+		// Try :name format, then $name format
+		idx, ok := paramIndices[":"+name]
+		if !ok {
+			idx, ok = paramIndices["$"+name]
+		}
+		if !ok {
 			continue
 		}
-		// Try $name format
-		if idx, ok := paramIndices["$"+name]; ok {
+
+		switch types[name] {
+		case ParamInt, ParamBool:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("param %s: %w", name, err)
+			}
+			stmt.BindInt64(idx, n)
+		case ParamFloat:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("param %s: %w", name, err)
+			}
+			stmt.BindFloat(idx, f)
+		case ParamFile:
+			stmt.BindBytes(idx, []byte(value))
+		default:
 			stmt.BindText(idx, value)
 		}
 	}
 	return nil
 }
 
-// isSelectQuery checks if a query is a SELECT statement.
-func isSelectQuery(sql string) bool {
-	trimmed := strings.TrimSpace(strings.ToUpper(sql))
-	return strings.HasPrefix(trimmed, "SELECT") ||
-		strings.HasPrefix(trimmed, "WITH") ||
-		strings.HasPrefix(trimmed, "PRAGMA")
-}
-
 // getColumnValue extracts a value from a result column.
 func getColumnValue(stmt *sqlite.Stmt, idx int) interface{} {
 	switch stmt.ColumnType(idx) {
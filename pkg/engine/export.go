@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// errExported stops a file run once its exported query streamed.
+var errExported = errors.New("query exported")
+
+// RowWriter receives the rows of an exported query.
+type RowWriter interface {
+	// Columns is called once, before the first row
+	Columns(columns []string) error
+
+	// Row is called for each row; values is reused between calls
+	Row(values []interface{}) error
+
+	// Close is called once all the rows are written
+	Close() error
+}
+
+// Export selects the query of a file whose rows are streamed, e.g. as a
+// download, instead of being collected into a result.
+type Export struct {
+	// Match reports whether a query is to be exported. The first matching
+	// query that runs is, so a query in an @if branch that is not taken
+	// is not; one repeated by @each is streamed once per row.
+	Match func(Query) bool
+
+	// Start is called once the exported query is reached and prepared,
+	// before it runs (e.g. to commit the writes of the queries before it),
+	// and returns the writer its rows are passed to
+	Start func(Query) (RowWriter, error)
+
+	// all is set by ExecuteStream, which streams every matching query
+	all bool
+}
+
+// ExecuteExport executes file like ExecuteFile until the query selected
+// by export runs: its rows are passed to the export's writer as they are
+// stepped, without collecting them, so that exports of any size run in
+// constant memory, and the queries after it don't run. The writer is
+// closed once the export is over. It reports false when no query was
+// exported, with the results of the whole file.
+func (e *Executor) ExecuteExport(ctx context.Context, conn *sqlite.Conn, file *File, params Params, export *Export) (results []*Result, exported bool, err error) {
+	exported, err = e.run(ctx, conn, file, params, func(result *Result) error {
+		results = append(results, result)
+		return nil
+	}, export)
+	return results, exported, err
+}
+
+// ExecuteStream executes file like ExecuteFileFunc, except that every
+// query selected by export that runs is streamed instead of passed to fn:
+// its rows go to the writer Start returns for it as they are stepped, e.g.
+// to render a page component by component without holding more than a
+// row of each. The other queries run and reach fn as usual.
+func (e *Executor) ExecuteStream(ctx context.Context, conn *sqlite.Conn, file *File, params Params, fn func(*Result) error, export *Export) error {
+	all := *export
+	all.all = true
+	_, err := e.run(ctx, conn, file, params, fn, &all)
+	return err
+}
+
+// exports reports whether query is one the run streams.
+func (r *fileRun) exports(query Query) bool {
+	return r.export != nil && (r.export.all || !r.exported) && r.export.Match(query)
+}
+
+// stream runs an exported query, passing its rows to the export's writer
+// as they are stepped. Repeated by @each, an export writes the columns
+// once; with ExecuteStream, each run is a query of its own, closed once
+// its rows are written.
+func (r *fileRun) stream(query Query) error {
+	// A query that doesn't prepare fails before Start commits anything
+	stmt, release, err := r.executor.prepare(r.conn, query, r.params, r.types)
+	if err != nil {
+		return fmt.Errorf("%s: query %q: %w", query.Location(), query.Component, err)
+	}
+	defer release()
+
+	first := r.writer == nil
+	if first {
+		w, err := r.export.Start(query)
+		if err != nil {
+			return err
+		}
+		r.writer = w
+	}
+
+	colCount := stmt.ColumnCount()
+	if first {
+		columns := make([]string, colCount)
+		for i := range columns {
+			columns[i] = stmt.ColumnName(i)
+		}
+		if err := r.writer.Columns(columns); err != nil {
+			return err
+		}
+		r.exported = true
+	}
+
+	values := make([]interface{}, colCount)
+	for {
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return fmt.Errorf("%s: query %q: step: %w", query.Location(), query.Component, err)
+		}
+		if !hasRow {
+			break
+		}
+		for i := range values {
+			values[i] = getColumnValue(stmt, i)
+		}
+		if err := r.writer.Row(values); err != nil {
+			return err
+		}
+	}
+
+	if !r.export.all {
+		return nil
+	}
+	w := r.writer
+	r.writer = nil
+	return w.Close()
+}
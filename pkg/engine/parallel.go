@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// independent reports whether query can run alongside its neighbours: a
+// read-only query that binds no variables, run outside of a transaction
+// (whose own writes only its connection sees), and not exported.
+func (r *fileRun) independent(query Query) bool {
+	switch query.Component {
+	case "if", "else", "end", "each", "authenticate", "set":
+		return false
+	}
+	return query.ReadOnly && r.executor.Readers != nil && r.conn.AutocommitEnabled() && !r.exports(query)
+}
+
+// batch runs queries that don't depend on each other on the run's
+// connection and on as many extra readers as are free, then emits their
+// results in declaration order, each as soon as it and those before it
+// are done. Every connection reads the database as committed when its
+// query starts: SQLite connections can't share a snapshot here, so a
+// write committed meanwhile may be seen by some queries of the batch only.
+func (r *fileRun) batch(queries []Query) error {
+	if len(queries) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
+	conns := []*sqlite.Conn{r.conn}
+	for len(conns) < len(queries) {
+		conn, release, ok := r.executor.Readers(ctx)
+		if !ok {
+			break
+		}
+		defer release()
+		conns = append(conns, conn)
+	}
+	if len(conns) == 1 {
+		for _, query := range queries {
+			if err := r.query(query); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// The run's connection is interrupted with the others when the batch
+	// fails, until the batch is over
+	defer r.conn.SetInterrupt(r.conn.SetInterrupt(ctx.Done()))
+
+	type outcome struct {
+		result *Result
+		err    error
+	}
+	outcomes := make([]outcome, len(queries))
+	done := make([]chan struct{}, len(queries))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	// Each connection takes the next query to run until none is left
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(queries) {
+					return
+				}
+				result, err := r.executor.execute(ctx, conn, queries[i], r.params, r.types)
+				outcomes[i] = outcome{result: result, err: err}
+				close(done[i])
+			}
+		}()
+	}
+
+	var err error
+	for i, query := range queries {
+		<-done[i]
+		if err = r.done(query, outcomes[i].result, outcomes[i].err); err != nil {
+			break
+		}
+	}
+	cancel()
+	wg.Wait()
+	return err
+}
@@ -0,0 +1,351 @@
+package engine
+
+import (
+	"cmp"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parameter types supported by -- @param declarations.
+const (
+	ParamText  = "text"
+	ParamInt   = "int"
+	ParamFloat = "float"
+	ParamBool  = "bool"
+	ParamDate  = "date"
+	ParamEmail = "email"
+	ParamFile  = "file"
+)
+
+// dateLayout is the canonical format for date parameters (HTML date inputs).
+const dateLayout = "2006-01-02"
+
+// ParamSpec declares a typed parameter of a SQL file.
+//
+//	-- @param page type=int default=1 min=1
+//	-- @param email type=email required
+//	-- @param avatar type=file max_size=1048576 accept="image/png,image/jpeg"
+type ParamSpec struct {
+	// Name is the parameter name, without the $ or : prefix
+	Name string
+
+	// Type is one of the Param* constants (text when omitted)
+	Type string
+
+	// Required rejects requests where the parameter is missing or empty
+	Required bool
+
+	// Default is used when the parameter is missing (if HasDefault is set)
+	Default    string
+	HasDefault bool
+
+	// Min and Max bound the value (numbers, dates) or its length (text)
+	Min string
+	Max string
+
+	// Pattern must match the whole value when set
+	Pattern *regexp.Regexp
+
+	// MaxSize limits the size in bytes of a file parameter (0 means no limit)
+	MaxSize int64
+
+	// Accept lists the MIME types allowed for a file parameter; a type
+	// may end with /* to allow a whole family (image/*)
+	Accept []string
+}
+
+// FieldError describes why a single parameter was rejected.
+type FieldError struct {
+	Name    string
+	Message string
+}
+
+// ValidationError is returned when request parameters do not satisfy
+// the @param declarations of a file.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Name + ": " + f.Message
+	}
+	return "invalid parameters: " + strings.Join(msgs, "; ")
+}
+
+// parseParamSpec parses the remainder of a -- @param annotation.
+func parseParamSpec(name, rest string) (ParamSpec, error) {
+	spec := ParamSpec{Name: name, Type: ParamText}
+
+	for key, value := range parseOptions(rest) {
+		switch key {
+		case "type":
+			switch value {
+			case ParamText, ParamInt, ParamFloat, ParamBool, ParamDate, ParamEmail, ParamFile:
+				spec.Type = value
+			default:
+				return spec, fmt.Errorf("param %s: unknown type %q", name, value)
+			}
+		case "required":
+			spec.Required = value != "false"
+		case "default":
+			spec.Default = value
+			spec.HasDefault = true
+		case "min":
+			spec.Min = value
+		case "max":
+			spec.Max = value
+		case "pattern":
+			re, err := regexp.Compile(`^(?:` + value + `)$`)
+			if err != nil {
+				return spec, fmt.Errorf("param %s: invalid pattern: %w", name, err)
+			}
+			spec.Pattern = re
+		case "max_size":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return spec, fmt.Errorf("param %s: invalid max_size %q", name, value)
+			}
+			spec.MaxSize = n
+		case "accept":
+			for _, t := range strings.Split(value, ",") {
+				if t = strings.TrimSpace(t); t != "" {
+					spec.Accept = append(spec.Accept, strings.ToLower(t))
+				}
+			}
+		default:
+			return spec, fmt.Errorf("param %s: unknown option %q", name, key)
+		}
+	}
+
+	// Bounds are checked once here so that a typo in the page shows up
+	// as a parse error rather than as a rejected request.
+	for _, bound := range []string{spec.Min, spec.Max} {
+		if bound == "" {
+			continue
+		}
+		var err error
+		switch spec.Type {
+		case ParamText, ParamEmail:
+			_, err = strconv.Atoi(bound)
+		case ParamBool:
+			err = fmt.Errorf("bool parameters have no bounds")
+		case ParamFile:
+			err = fmt.Errorf("file parameters have no bounds, use max_size")
+		default:
+			_, err = spec.normalize(bound)
+		}
+		if err != nil {
+			return spec, fmt.Errorf("param %s: invalid bound %q: %w", name, bound, err)
+		}
+	}
+
+	return spec, nil
+}
+
+// optionTokenRegex matches key=value, key="value with spaces" or a bare flag.
+var optionTokenRegex = regexp.MustCompile(`(\w+)(?:=(?:"([^"]*)"|(\S+)))?`)
+
+// parseOptions parses annotation options, including bare flags such as
+// "required" which are recorded with the value "true".
+func parseOptions(s string) map[string]string {
+	opts := make(map[string]string)
+	for _, m := range optionTokenRegex.FindAllStringSubmatch(s, -1) {
+		value := m[2]
+		if value == "" {
+			value = m[3]
+		}
+		if !strings.Contains(m[0], "=") {
+			value = "true"
+		}
+		opts[m[1]] = value
+	}
+	return opts
+}
+
+// ValidateParams checks params against the declared specs and returns a copy
+// with defaults applied and typed values in canonical form.
+// Undeclared parameters are passed through unchanged.
+func ValidateParams(specs []ParamSpec, params Params) (Params, error) {
+	if len(specs) == 0 {
+		return params, nil
+	}
+
+	out := make(Params, len(params))
+	for k, v := range params {
+		out[k] = v
+	}
+
+	var errs []FieldError
+	for _, spec := range specs {
+		value, ok := out[spec.Name]
+		if !ok || value == "" {
+			switch {
+			case spec.HasDefault:
+				value = spec.Default
+			case spec.Required:
+				errs = append(errs, FieldError{Name: spec.Name, Message: "is required"})
+				continue
+			default:
+				// Absent optional parameters are bound as NULL
+				delete(out, spec.Name)
+				continue
+			}
+		}
+
+		if spec.Type == ParamFile {
+			// The value holds the file content, its MIME type is set
+			// alongside it by the server as <name>_mime
+			if err := spec.checkFile(value, out[spec.Name+"_mime"]); err != nil {
+				errs = append(errs, FieldError{Name: spec.Name, Message: err.Error()})
+			}
+			continue
+		}
+
+		normalized, err := spec.check(value)
+		if err != nil {
+			errs = append(errs, FieldError{Name: spec.Name, Message: err.Error()})
+			continue
+		}
+		out[spec.Name] = normalized
+	}
+
+	if len(errs) > 0 {
+		return nil, &ValidationError{Fields: errs}
+	}
+	return out, nil
+}
+
+// check validates a single value and returns its canonical form.
+func (s ParamSpec) check(value string) (string, error) {
+	normalized, err := s.normalize(value)
+	if err != nil {
+		return "", err
+	}
+
+	if s.Pattern != nil && !s.Pattern.MatchString(value) {
+		return "", fmt.Errorf("has an invalid format")
+	}
+
+	byLength := s.Type == ParamText || s.Type == ParamEmail
+	if s.Min != "" && s.compare(normalized, s.Min) < 0 {
+		if byLength {
+			return "", fmt.Errorf("must be at least %s characters", s.Min)
+		}
+		return "", fmt.Errorf("must be at least %s", s.Min)
+	}
+	if s.Max != "" && s.compare(normalized, s.Max) > 0 {
+		if byLength {
+			return "", fmt.Errorf("must be at most %s characters", s.Max)
+		}
+		return "", fmt.Errorf("must be at most %s", s.Max)
+	}
+
+	return normalized, nil
+}
+
+// checkFile validates an uploaded file against max_size and accept.
+func (s ParamSpec) checkFile(content, mimeType string) error {
+	if s.MaxSize > 0 && int64(len(content)) > s.MaxSize {
+		return fmt.Errorf("must be at most %d bytes", s.MaxSize)
+	}
+	if len(s.Accept) == 0 {
+		return nil
+	}
+	mimeType = strings.ToLower(mimeType)
+	for _, t := range s.Accept {
+		if t == mimeType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(t, "*"))) {
+			return nil
+		}
+	}
+	return fmt.Errorf("must be of type %s", strings.Join(s.Accept, ", "))
+}
+
+// normalize parses a value according to the spec type.
+func (s ParamSpec) normalize(value string) (string, error) {
+	switch s.Type {
+	case ParamInt:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("must be an integer")
+		}
+		return strconv.FormatInt(n, 10), nil
+
+	case ParamFloat:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return "", fmt.Errorf("must be a number")
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64), nil
+
+	case ParamBool:
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "1", "true", "on", "yes":
+			return "1", nil
+		case "0", "false", "off", "no":
+			return "0", nil
+		}
+		return "", fmt.Errorf("must be a boolean")
+
+	case ParamDate:
+		t, err := time.Parse(dateLayout, strings.TrimSpace(value))
+		if err != nil {
+			return "", fmt.Errorf("must be a date (YYYY-MM-DD)")
+		}
+		return t.Format(dateLayout), nil
+
+	case ParamEmail:
+		addr, err := mail.ParseAddress(value)
+		if err != nil || addr.Address != strings.TrimSpace(value) {
+			return "", fmt.Errorf("must be a valid email address")
+		}
+		return addr.Address, nil
+
+	default:
+		return value, nil
+	}
+}
+
+// compare orders a normalized value against a bound.
+// Text and email values are compared by length, and integers as such
+// rather than as floats, which lose precision above 2^53.
+func (s ParamSpec) compare(value, bound string) int {
+	switch s.Type {
+	case ParamInt:
+		a, _ := strconv.ParseInt(value, 10, 64)
+		b, _ := strconv.ParseInt(bound, 10, 64)
+		return cmp.Compare(a, b)
+	case ParamFloat:
+		a, _ := strconv.ParseFloat(value, 64)
+		b, _ := strconv.ParseFloat(bound, 64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case ParamDate:
+		return strings.Compare(value, bound)
+	default:
+		n, _ := strconv.Atoi(bound)
+		return len([]rune(value)) - n
+	}
+}
+
+// paramTypes maps declared parameter names to their types for binding.
+func paramTypes(specs []ParamSpec) map[string]string {
+	if len(specs) == 0 {
+		return nil
+	}
+	types := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		types[spec.Name] = spec.Type
+	}
+	return types
+}
@@ -5,8 +5,10 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 // Query represents a parsed SQL query with its metadata.
@@ -19,12 +21,101 @@ type Query struct {
 
 	// Options are key-value pairs from the query annotation
 	Options map[string]string
+
+	// ReadOnly is set by Executor.Classify when the query doesn't write
+	ReadOnly bool
+
+	// File and Line locate the query's annotation, in the included file
+	// for queries inlined by -- @include
+	File string
+	Line int
+
+	// Overrides are the key=value params of the -- @include that inlined
+	// the query; a value starting with $ names another param
+	Overrides map[string]string
+}
+
+// Location returns the query's position as file:line for error messages.
+func (q Query) Location() string {
+	return fmt.Sprintf("%s:%d", q.File, q.Line)
 }
 
 // File represents a parsed SQL file containing multiple queries.
 type File struct {
 	Path    string
 	Queries []Query
+
+	// Params are the typed parameters declared with -- @param
+	Params []ParamSpec
+
+	// Options are page-level options from -- @page annotations
+	Options map[string]string
+
+	// Includes lists the files inlined with -- @include, recursively
+	Includes []string
+
+	// classified is set once Executor.Classify has run
+	classified bool
+}
+
+// Writes reports whether any query of the file modifies the database.
+// It is only meaningful after Executor.Classify.
+func (f *File) Writes() bool {
+	for _, q := range f.Queries {
+		if !q.ReadOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// Timeout returns the duration set with -- @page timeout=, and false if
+// the page sets none. A zero timeout disables the server's default one.
+func (f *File) Timeout() (time.Duration, bool) {
+	v, ok := f.Options["timeout"]
+	if !ok {
+		return 0, false
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil
+}
+
+// Classified reports whether Executor.Classify has run on f.
+func (f *File) Classified() bool {
+	return f.classified
+}
+
+// Fragment returns a copy of f reduced to the queries with the option
+// id=<id>, plus those they may depend on: authenticate and @set queries
+// and the @if blocks around them. An @each is kept when it repeats one of
+// the fragment's queries. It reports false when no query has that id.
+func (f *File) Fragment(id string) (*File, bool) {
+	var queries []Query
+	found := false
+	for i := 0; i < len(f.Queries); i++ {
+		q := f.Queries[i]
+		switch {
+		case q.Component == "each":
+			// checkBlocks guarantees that a query follows
+			if f.Queries[i+1].Options["id"] == id {
+				queries = append(queries, q, f.Queries[i+1])
+				found = true
+			}
+			i++
+		case q.Options["id"] == id:
+			queries = append(queries, q)
+			found = true
+		case q.Component == "authenticate" || q.Component == "set" || isControl(q.Component):
+			queries = append(queries, q)
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	fragment := *f
+	fragment.Queries = queries
+	return &fragment, true
 }
 
 // Parser parses SQL files with GoPage conventions.
@@ -38,6 +129,24 @@ func NewParser() *Parser {
 // queryAnnotationRegex matches: -- @query component=table title="My Title" ...
 var queryAnnotationRegex = regexp.MustCompile(`^--\s*@query\s+(.*)$`)
 
+// paramAnnotationRegex matches: -- @param name type=int required ...
+var paramAnnotationRegex = regexp.MustCompile(`^--\s*@param\s+(\w+)(.*)$`)
+
+// setAnnotationRegex matches: -- @set name or -- @each name (the name is optional)
+var setAnnotationRegex = regexp.MustCompile(`^--\s*@(set|each)(?:\s+(\w+))?\s*$`)
+
+// ifAnnotationRegex matches: -- @if <sql expression>
+var ifAnnotationRegex = regexp.MustCompile(`^--\s*@if\s+(.+)$`)
+
+// blockAnnotationRegex matches: -- @else and -- @end
+var blockAnnotationRegex = regexp.MustCompile(`^--\s*@(else|end)\s*$`)
+
+// includeAnnotationRegex matches: -- @include _partials/nav.sql key=value ...
+var includeAnnotationRegex = regexp.MustCompile(`^--\s*@include\s+(\S+)(.*)$`)
+
+// pageAnnotationRegex matches: -- @page readonly ...
+var pageAnnotationRegex = regexp.MustCompile(`^--\s*@page\b(.*)$`)
+
 // optionRegex matches: key=value or key="value with spaces"
 var optionRegex = regexp.MustCompile(`(\w+)=(?:"([^"]+)"|(\S+))`)
 
@@ -59,21 +168,75 @@ func (p *Parser) ParseFile(path string) (*File, error) {
 //
 //	-- @query component=text
 //	SELECT 'Hello World' as content;
+//
+// Typed parameters can be declared anywhere in the file:
+//
+//	-- @param id type=int required min=1
+//
+// A -- @set query is executed but not rendered: the columns of its first row
+// become params of the following queries (see Executor.ExecuteFile):
+//
+//	-- @set user
+//	SELECT id, role FROM users WHERE id = $_cookie_user;  -- $user_id, $user_role
+//
+// Queries can be run conditionally, the expression being evaluated by SQLite
+// against the current params, or once per row of a driving query:
+//
+//	-- @if $category IS NOT NULL
+//	-- @query component=table
+//	SELECT * FROM posts WHERE category = $category;
+//	-- @else
+//	-- @query component=table
+//	SELECT * FROM posts;
+//	-- @end
+//
+//	-- @each
+//	SELECT id AS category_id FROM categories;
+//	-- @query component=table
+//	SELECT * FROM posts WHERE category_id = $category_id;
+//
+// Another file's queries can be inlined, relative to the current file, with
+// params overridden for them only:
+//
+//	-- @include _partials/nav.sql active=users title=$page_title
+//
+// Page-level options use -- @page, e.g. to assert that the page never writes:
+//
+//	-- @page readonly
+//
+// or to bound its execution time (overriding the server's default):
+//
+//	-- @page timeout=2s
+//
+// or to send each component as soon as its query ran:
+//
+//	-- @page stream
 func (p *Parser) Parse(path, content string) (*File, error) {
+	return p.parse(path, content, nil)
+}
+
+// parse parses content; including lists the files being parsed that
+// include this one, to detect cycles.
+func (p *Parser) parse(path, content string, including []string) (*File, error) {
 	file := &File{
 		Path:    path,
 		Queries: []Query{},
+		Options: make(map[string]string),
 	}
 
 	scanner := bufio.NewScanner(strings.NewReader(content))
 	var currentQuery *Query
 	var sqlBuilder strings.Builder
 
+	var lineNo, queryLine int
+
 	flushQuery := func() {
 		if currentQuery != nil {
 			sql := strings.TrimSpace(sqlBuilder.String())
 			if sql != "" {
 				currentQuery.SQL = sql
+				currentQuery.File = path
+				currentQuery.Line = queryLine
 				file.Queries = append(file.Queries, *currentQuery)
 			}
 		}
@@ -81,14 +244,87 @@ func (p *Parser) Parse(path, content string) (*File, error) {
 		sqlBuilder.Reset()
 	}
 
+	// Control annotations are complete on their line
+	addControl := func(q Query) {
+		flushQuery()
+		q.File = path
+		q.Line = lineNo
+		file.Queries = append(file.Queries, q)
+	}
+
 	for scanner.Scan() {
 		line := scanner.Text()
 		trimmed := strings.TrimSpace(line)
+		lineNo++
+		if currentQuery == nil {
+			queryLine = lineNo
+		}
+
+		// Check for param declaration
+		if matches := paramAnnotationRegex.FindStringSubmatch(trimmed); matches != nil {
+			spec, err := parseParamSpec(matches[1], matches[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+			}
+			file.Params = append(file.Params, spec)
+			continue
+		}
+
+		// Check for page options
+		if matches := pageAnnotationRegex.FindStringSubmatch(trimmed); matches != nil {
+			for key, value := range parseOptions(matches[1]) {
+				file.Options[key] = value
+			}
+			if v, ok := file.Options["timeout"]; ok {
+				if d, err := time.ParseDuration(v); err != nil || d < 0 {
+					return nil, fmt.Errorf("%s:%d: invalid timeout %q", path, lineNo, v)
+				}
+			}
+			continue
+		}
+
+		// Check for include
+		if matches := includeAnnotationRegex.FindStringSubmatch(trimmed); matches != nil {
+			flushQuery()
+			if err := p.include(file, matches[1], parseOptions(matches[2]), append(including, path)); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+			}
+			continue
+		}
+
+		// Check for set/each annotation, shorthand for component=set name=...
+		if matches := setAnnotationRegex.FindStringSubmatch(trimmed); matches != nil {
+			flushQuery()
+			queryLine = lineNo
+			currentQuery = &Query{
+				Component: matches[1],
+				Options:   make(map[string]string),
+			}
+			if matches[2] != "" {
+				currentQuery.Options["name"] = matches[2]
+			}
+			continue
+		}
+
+		// Check for control flow annotations
+		if matches := ifAnnotationRegex.FindStringSubmatch(trimmed); matches != nil {
+			addControl(Query{
+				Component: "if",
+				SQL:       "SELECT CASE WHEN (" + matches[1] + ") THEN 1 ELSE 0 END",
+				Options:   map[string]string{"expr": matches[1]},
+			})
+			continue
+		}
+		if matches := blockAnnotationRegex.FindStringSubmatch(trimmed); matches != nil {
+			addControl(Query{Component: matches[1], Options: make(map[string]string)})
+			continue
+		}
 
 		// Check for query annotation
 		if matches := queryAnnotationRegex.FindStringSubmatch(trimmed); matches != nil {
 			// Flush previous query
 			flushQuery()
+			queryLine = lineNo
 
 			// Parse new query
 			currentQuery = &Query{
@@ -147,9 +383,117 @@ func (p *Parser) Parse(path, content string) (*File, error) {
 		return nil, fmt.Errorf("scan error: %w", err)
 	}
 
+	if err := checkBlocks(file.Queries); err != nil {
+		return nil, err
+	}
+
 	return file, nil
 }
 
+// include parses the file at name, relative to the including file, and
+// appends its queries, params and page options to file.
+func (p *Parser) include(file *File, name string, overrides map[string]string, including []string) error {
+	if filepath.IsAbs(name) {
+		return fmt.Errorf("include %s: path must be relative", name)
+	}
+	path := filepath.Join(filepath.Dir(file.Path), name)
+	for _, parent := range including {
+		if filepath.Clean(parent) == path {
+			return fmt.Errorf("include %s: cycle through %s", name, strings.Join(append(including, path), " -> "))
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("include %s: %w", name, err)
+	}
+	inc, err := p.parse(path, string(content), including)
+	if err != nil {
+		return err
+	}
+
+	for _, q := range inc.Queries {
+		// Overrides of nested includes are closer to the query and win
+		merged := make(map[string]string, len(overrides)+len(q.Overrides))
+		for k, v := range overrides {
+			merged[k] = v
+		}
+		for k, v := range q.Overrides {
+			merged[k] = v
+		}
+		if len(merged) > 0 {
+			q.Overrides = merged
+		}
+		file.Queries = append(file.Queries, q)
+	}
+
+	// Declarations of the page itself win over those of its partials
+	for _, spec := range inc.Params {
+		declared := false
+		for _, existing := range file.Params {
+			declared = declared || existing.Name == spec.Name
+		}
+		if !declared {
+			file.Params = append(file.Params, spec)
+		}
+	}
+	for k, v := range inc.Options {
+		if _, ok := file.Options[k]; !ok {
+			file.Options[k] = v
+		}
+	}
+
+	file.Includes = append(file.Includes, path)
+	file.Includes = append(file.Includes, inc.Includes...)
+	return nil
+}
+
+// checkBlocks checks that @if/@else/@end blocks are balanced and that
+// each @each is followed by the query it repeats.
+func checkBlocks(queries []Query) error {
+	var stack []Query
+	hasElse := make(map[int]bool)
+
+	for i, q := range queries {
+		switch q.Component {
+		case "if":
+			stack = append(stack, q)
+		case "else":
+			if len(stack) == 0 {
+				return fmt.Errorf("%s: @else without @if", q.Location())
+			}
+			if hasElse[len(stack)] {
+				return fmt.Errorf("%s: duplicate @else", q.Location())
+			}
+			hasElse[len(stack)] = true
+		case "end":
+			if len(stack) == 0 {
+				return fmt.Errorf("%s: @end without @if", q.Location())
+			}
+			hasElse[len(stack)] = false
+			stack = stack[:len(stack)-1]
+		case "each":
+			if i+1 == len(queries) || isControl(queries[i+1].Component) {
+				return fmt.Errorf("%s: @each must be followed by a query", q.Location())
+			}
+		}
+	}
+	if len(stack) > 0 {
+		return fmt.Errorf("%s: @if without @end", stack[len(stack)-1].Location())
+	}
+	return nil
+}
+
+// isControl reports whether component is a control flow annotation
+// rather than a query to render.
+func isControl(component string) bool {
+	switch component {
+	case "if", "else", "end", "each":
+		return true
+	}
+	return false
+}
+
 // ExtractParams finds all parameter placeholders in a query.
 // Supports $param and :param syntax.
 func ExtractParams(sql string) []string {
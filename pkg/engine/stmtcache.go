@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"container/list"
+	"errors"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// DefaultStatementCacheSize is the number of statements an Executor keeps
+// prepared per connection when its StatementCacheSize is zero.
+const DefaultStatementCacheSize = 256
+
+// errNoCache makes prepare fall back to a transient statement when the
+// statement cache is disabled.
+var errNoCache = errors.New("statement cache disabled")
+
+// stmtCache orders the statements an Executor cached on a connection, most
+// recently used first. The connection's own cache, filled by conn.Prepare,
+// is unbounded: statements evicted here are finalized, which removes them
+// from it, so that SQL files edited over time don't pile up statements.
+type stmtCache struct {
+	lru   *list.List
+	elems map[*sqlite.Stmt]*list.Element
+}
+
+// cached records a use of stmt, prepared on conn with conn.Prepare, and
+// finalizes the least recently used statements beyond the cache size.
+func (e *Executor) cached(conn *sqlite.Conn, stmt *sqlite.Stmt) {
+	e.stmtsMu.Lock()
+	if e.stmts == nil {
+		e.stmts = make(map[*sqlite.Conn]*stmtCache)
+	}
+	c := e.stmts[conn]
+	if c == nil {
+		c = &stmtCache{lru: list.New(), elems: make(map[*sqlite.Stmt]*list.Element)}
+		e.stmts[conn] = c
+	}
+	e.stmtsMu.Unlock()
+
+	// Only the goroutine holding conn uses its cache from here
+	if elem, ok := c.elems[stmt]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.elems[stmt] = c.lru.PushFront(stmt)
+
+	size := e.StatementCacheSize
+	if size == 0 {
+		size = DefaultStatementCacheSize
+	}
+	for c.lru.Len() > size {
+		old := c.lru.Remove(c.lru.Back()).(*sqlite.Stmt)
+		delete(c.elems, old)
+		old.Finalize()
+	}
+}
@@ -0,0 +1,420 @@
+// Package lint checks the SQL files of a GoPage site without serving them:
+// statements are prepared against the target database, and the params,
+// components and options they use are checked against what the server
+// provides.
+package lint
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hazyhaar/gopage/pkg/engine"
+	"github.com/hazyhaar/gopage/pkg/render"
+	"zombiezen.com/go/sqlite"
+)
+
+// Diagnostic is a problem found in a SQL file.
+type Diagnostic struct {
+	File string
+
+	// Line is the line of the query annotation, 0 for the whole file
+	Line int
+
+	Message string
+}
+
+// String formats d as file:line: message.
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", d.File, d.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+}
+
+// Config holds linter configuration.
+type Config struct {
+	// SQLDir is the directory of the SQL files, as served with -sql
+	SQLDir string
+
+	// Conn is a connection to the target database, with the custom SQL
+	// functions registered. Statements are prepared on it, never run.
+	Conn *sqlite.Conn
+
+	// Renderer provides the registered components
+	Renderer *render.Renderer
+
+	// Templates are the template directories of the renderer, whose links
+	// (e.g. pagination) provide params like those of SQL files
+	Templates []fs.FS
+}
+
+// serverComponents are handled by the server or the executor instead of
+// being rendered.
+var serverComponents = map[string]bool{
+	"redirect":     true,
+	"refresh":      true,
+	"trigger":      true,
+	"header":       true,
+	"cookie":       true,
+	"authenticate": true,
+	"set":          true,
+	"download":     true,
+	"dynamic":      true,
+	"if":           true,
+	"else":         true,
+	"end":          true,
+	"each":         true,
+}
+
+// pageOptions are the options of -- @page.
+var pageOptions = map[string]bool{
+	"readonly": true,
+	"timeout":  true,
+	"stream":   true,
+}
+
+// downloadFormats are the formats of the download component.
+var downloadFormats = map[string]bool{
+	"csv":    true,
+	"tsv":    true,
+	"ndjson": true,
+}
+
+// requestVariables are the reserved params of every request, besides
+// $_cookie_<name> and $_header_<name>.
+var requestVariables = map[string]bool{
+	"_method":      true,
+	"_path":        true,
+	"_remote_addr": true,
+	"_user_agent":  true,
+	"_request_id":  true,
+}
+
+// uploadSuffixes name the params bound for a type=file param.
+var uploadSuffixes = []string{"", "_name", "_size", "_mime", "_path"}
+
+var (
+	// errorPageRegex matches the names of error pages: _404.sql, _error.sql
+	errorPageRegex = regexp.MustCompile(`^_(\d{3}|error)\.sql$`)
+
+	// linkParamRegex matches a query string param: ?id= or &page=
+	linkParamRegex = regexp.MustCompile(`[?&](\w+)=`)
+
+	// fieldNameRegex matches an HTML form field name: name="title"
+	fieldNameRegex = regexp.MustCompile(`\bname=["']{1,2}(\w+)`)
+
+	// literalRegex matches a SQL string literal that could be a field name
+	literalRegex = regexp.MustCompile(`'(\w+)'`)
+
+	// segmentRegex matches a dynamic route segment: [id]
+	segmentRegex = regexp.MustCompile(`\[(\w+)\]`)
+
+	// locationRegex matches an error located as file:line: message
+	locationRegex = regexp.MustCompile(`^(.+?):(\d+): (.*)$`)
+)
+
+// linter holds the state of a Run.
+type linter struct {
+	cfg      Config
+	executor *engine.Executor
+
+	// files are the parsed pages, guards and error pages by path
+	files map[string]*engine.File
+
+	// external are the params links and forms of any file provide
+	external map[string]bool
+
+	diags []Diagnostic
+}
+
+// Run checks the SQL files of cfg.SQLDir and returns the problems found,
+// sorted by file and line. Files in directories starting with "_"
+// (_migrations, _partials) are only checked where they are included.
+func Run(cfg Config) ([]Diagnostic, error) {
+	l := &linter{
+		cfg:      cfg,
+		executor: engine.NewExecutor(),
+		files:    make(map[string]*engine.File),
+		external: make(map[string]bool),
+	}
+
+	var pages []string
+	err := filepath.WalkDir(cfg.SQLDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == "_migrations" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".sql" {
+			return nil
+		}
+
+		// Links and form fields of any file, partials included, can
+		// provide params to the pages they point to
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		l.scanLinks(content)
+
+		rel, err := filepath.Rel(cfg.SQLDir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(rel, "_") && !strings.Contains(rel, string(filepath.Separator)+"_") {
+			pages = append(pages, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fsys := range cfg.Templates {
+		err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || filepath.Ext(path) != ".html" {
+				return err
+			}
+			content, err := fs.ReadFile(fsys, path)
+			if err != nil {
+				return err
+			}
+			l.scanLinks(content)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	parser := engine.NewParser()
+	for _, path := range pages {
+		file, err := parser.ParseFile(path)
+		if err != nil {
+			l.reportError(path, err)
+			continue
+		}
+		l.files[path] = file
+
+		// The rows of a form query define its fields
+		for _, q := range file.Queries {
+			if q.Component == "form" {
+				for _, m := range literalRegex.FindAllStringSubmatch(q.SQL, -1) {
+					l.external[m[1]] = true
+				}
+			}
+		}
+	}
+
+	for _, path := range pages {
+		if file, ok := l.files[path]; ok {
+			l.check(path, file)
+		}
+	}
+
+	// Partials are checked once per including file, reported once
+	sort.SliceStable(l.diags, func(i, j int) bool {
+		a, b := l.diags[i], l.diags[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Message < b.Message
+	})
+	return slices.Compact(l.diags), nil
+}
+
+// scanLinks records the params of the links and form fields in content.
+func (l *linter) scanLinks(content []byte) {
+	for _, re := range []*regexp.Regexp{linkParamRegex, fieldNameRegex} {
+		for _, m := range re.FindAllSubmatch(content, -1) {
+			l.external[string(m[1])] = true
+		}
+	}
+}
+
+// report records a problem.
+func (l *linter) report(file string, line int, format string, args ...interface{}) {
+	l.diags = append(l.diags, Diagnostic{File: file, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// reportError records an error of the parser or the executor, located at
+// the file:line it starts with if any.
+func (l *linter) reportError(path string, err error) {
+	if m := locationRegex.FindStringSubmatch(err.Error()); m != nil {
+		if line, convErr := strconv.Atoi(m[2]); convErr == nil {
+			l.report(m[1], line, "%s", m[3])
+			return
+		}
+	}
+	l.report(path, 0, "%v", err)
+}
+
+// check checks the options, queries and params of a parsed file.
+func (l *linter) check(path string, file *engine.File) {
+	var options []string
+	for key := range file.Options {
+		if !pageOptions[key] {
+			options = append(options, key)
+		}
+	}
+	sort.Strings(options)
+	for _, key := range options {
+		l.report(path, 0, "unknown @page option %q", key)
+	}
+
+	known := l.provided(path, file)
+	errorPage := errorPageRegex.MatchString(filepath.Base(path))
+	prepared := true
+	for _, q := range file.Queries {
+		if !serverComponents[q.Component] && !l.cfg.Renderer.Has(q.Component) {
+			l.report(q.File, q.Line, "unknown component %q", q.Component)
+		}
+
+		// @else and @end have no statement
+		if q.SQL == "" {
+			continue
+		}
+		st, err := engine.Describe(l.cfg.Conn, q)
+		if err != nil {
+			l.report(q.File, q.Line, "query %q: %v", q.Component, err)
+			prepared = false
+			continue
+		}
+		if st.Trailing != "" {
+			first, _, _ := strings.Cut(st.Trailing, "\n")
+			l.report(q.File, q.Line, "only the first statement runs, not %q (annotate it with -- @query)", first)
+		}
+
+		for _, name := range st.Params {
+			_, overridden := q.Overrides[name]
+			if errorPage && strings.HasPrefix(name, "_error_field_") {
+				continue
+			}
+			if !known[name] && !overridden && !l.external[name] && !reserved(name) {
+				l.report(q.File, q.Line, "$%s is neither declared with -- @param nor set by the page, its guards, a link or a form", name)
+			}
+		}
+		for _, name := range engine.Variables(q, st.Columns) {
+			known[name] = true
+		}
+
+		l.checkOptions(q, st.Columns)
+	}
+
+	// Classify reports -- @page readonly violations, once every statement
+	// prepares
+	if prepared {
+		if err := l.executor.Classify(l.cfg.Conn, file); err != nil {
+			l.reportError(path, err)
+		}
+	}
+}
+
+// provided returns the params available to the file at path before its
+// first query: its declared params, path segments of dynamic routes, the
+// variables of the guards that run before it and those of error pages.
+func (l *linter) provided(path string, file *engine.File) map[string]bool {
+	known := make(map[string]bool)
+	declare := func(specs []engine.ParamSpec) {
+		for _, spec := range specs {
+			known[spec.Name] = true
+			if spec.Type == engine.ParamFile {
+				for _, suffix := range uploadSuffixes {
+					known[spec.Name+suffix] = true
+				}
+			}
+		}
+	}
+	declare(file.Params)
+
+	rel, _ := filepath.Rel(l.cfg.SQLDir, path)
+	for _, m := range segmentRegex.FindAllStringSubmatch(rel, -1) {
+		known[m[1]] = true
+	}
+
+	if errorPageRegex.MatchString(filepath.Base(path)) {
+		known["_error_status"] = true
+		known["_error_message"] = true
+		known["_error_fields"] = true
+	}
+
+	// Guards from the SQL root down to the file's directory
+	dir := filepath.Dir(path)
+	for {
+		guardPath := filepath.Join(dir, "_guard.sql")
+		if guard, ok := l.files[guardPath]; ok && guardPath != path {
+			declare(guard.Params)
+			for _, q := range guard.Queries {
+				if q.SQL == "" {
+					continue
+				}
+				if st, err := engine.Describe(l.cfg.Conn, q); err == nil {
+					for _, name := range engine.Variables(q, st.Columns) {
+						known[name] = true
+					}
+				}
+			}
+		}
+		if rel, err := filepath.Rel(l.cfg.SQLDir, dir); err != nil || rel == "." || rel == ".." {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	return known
+}
+
+// reserved reports whether name is a reserved request param.
+func reserved(name string) bool {
+	return requestVariables[name] ||
+		strings.HasPrefix(name, "_cookie_") ||
+		strings.HasPrefix(name, "_header_")
+}
+
+// checkOptions checks that a query has the options and columns its
+// component needs.
+func (l *linter) checkOptions(q engine.Query, columns []string) {
+	has := func(column string) bool {
+		return slices.Contains(columns, column)
+	}
+
+	switch q.Component {
+	case "redirect":
+		if q.Options["target"] == "" && !has("target") && !has("url") {
+			l.report(q.File, q.Line, "redirect needs a target option or a target or url column")
+		}
+	case "trigger":
+		if q.Options["event"] == "" {
+			l.report(q.File, q.Line, "trigger needs an event option")
+		}
+	case "cookie":
+		if q.Options["name"] == "" && !has("name") {
+			l.report(q.File, q.Line, "cookie needs a name option or a name column")
+		}
+	case "form":
+		if !has("name") {
+			l.report(q.File, q.Line, "form needs a name column")
+		}
+	case "download":
+		if format := q.Options["format"]; format != "" && !downloadFormats[format] {
+			l.report(q.File, q.Line, "unknown download format %q", format)
+		}
+	}
+
+	if oob := q.Options["oob"]; oob != "" && oob != "false" && q.Options["target"] == "" && q.Options["id"] == "" {
+		l.report(q.File, q.Line, "oob needs a target or id option")
+	}
+}
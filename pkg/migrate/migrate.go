@@ -0,0 +1,183 @@
+// Package migrate applies ordered schema migrations to a GoPage database.
+//
+// Migrations are SQL scripts named NNNN_name.sql, applied in version order.
+// Each one runs in its own transaction and is recorded with the SHA-256 of
+// its content in the _gopage_migrations table, so that an applied migration
+// edited afterwards is detected instead of silently diverging.
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// Table is the name of the table tracking applied migrations.
+const Table = "_gopage_migrations"
+
+// fileNameRegex matches migration file names: 0001_create_users.sql
+var fileNameRegex = regexp.MustCompile(`^(\d+)_([\w-]+)\.sql$`)
+
+// Migration is a migration script.
+type Migration struct {
+	Version  int
+	Name     string
+	Path     string
+	SQL      string
+	Checksum string
+}
+
+// ChecksumError is returned when an applied migration's file has changed.
+type ChecksumError struct {
+	Migration Migration
+
+	// Applied is the checksum recorded when the migration was applied
+	Applied string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("migration %s was modified after being applied (checksum %s, applied %s)",
+		filepath.Base(e.Migration.Path), short(e.Migration.Checksum), short(e.Applied))
+}
+
+// short abbreviates a checksum for messages, like git does for hashes.
+func short(checksum string) string {
+	if len(checksum) > 12 {
+		return checksum[:12]
+	}
+	return checksum
+}
+
+// Load reads the migrations of dir in version order.
+// A missing directory holds no migrations.
+func Load(dir string) ([]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		m := fileNameRegex.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid version: %w", entry.Name(), err)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("%s and %s share version %d", other, entry.Name(), version)
+		}
+		seen[version] = entry.Name()
+
+		path := filepath.Join(dir, entry.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(content)
+		migrations = append(migrations, Migration{
+			Version:  version,
+			Name:     m[2],
+			Path:     path,
+			SQL:      string(content),
+			Checksum: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Apply applies the migrations not yet recorded in the database and
+// returns them. It first checks the checksums of the applied ones and
+// returns a *ChecksumError, without applying anything, if one changed.
+// conn must be the writer connection.
+func Apply(conn *sqlite.Conn, migrations []Migration) ([]Migration, error) {
+	err := sqlitex.ExecuteTransient(conn, `CREATE TABLE IF NOT EXISTS `+Table+` (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+	)`, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", Table, err)
+	}
+
+	applied := make(map[int]string)
+	err = sqlitex.ExecuteTransient(conn, `SELECT version, checksum FROM `+Table, &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			applied[stmt.ColumnInt(0)] = stmt.ColumnText(1)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", Table, err)
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		checksum, ok := applied[m.Version]
+		if !ok {
+			pending = append(pending, m)
+			continue
+		}
+		if checksum != m.Checksum {
+			return nil, &ChecksumError{Migration: m, Applied: checksum}
+		}
+	}
+
+	var done []Migration
+	for _, m := range pending {
+		if err := apply(conn, m); err != nil {
+			return done, fmt.Errorf("migration %s: %w", filepath.Base(m.Path), err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// apply runs a migration and records it in a single transaction.
+func apply(conn *sqlite.Conn, m Migration) (err error) {
+	defer sqlitex.Save(conn)(&err)
+
+	if err := sqlitex.ExecuteScript(conn, m.SQL, nil); err != nil {
+		return err
+	}
+	return sqlitex.ExecuteTransient(conn,
+		`INSERT INTO `+Table+` (version, name, checksum) VALUES (?, ?, ?)`,
+		&sqlitex.ExecOptions{Args: []interface{}{m.Version, m.Name, m.Checksum}})
+}
+
+// Seed runs the SQL script at path in a single transaction, e.g. to load
+// demo data. Unlike migrations, seeds are not recorded and run every time:
+// they should only insert what is missing (INSERT OR IGNORE).
+// conn must be the writer connection.
+func Seed(conn *sqlite.Conn, path string) (err error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	defer sqlitex.Save(conn)(&err)
+	return sqlitex.ExecuteScript(conn, string(content), nil)
+}
@@ -24,6 +24,16 @@ func (c *TextComponent) Render(w io.Writer, result *engine.Result, data *PageDat
 	})
 }
 
+func (c *TextComponent) Stream(w io.Writer, result *StreamResult, data *PageData) error {
+	return c.tmpl.ExecuteTemplate(w, "text.html", struct {
+		Result  *StreamResult
+		Options map[string]string
+	}{
+		Result:  result,
+		Options: result.Query.Options,
+	})
+}
+
 // TableComponent renders data as an HTML table.
 type TableComponent struct {
 	tmpl *template.Template
@@ -41,6 +51,16 @@ func (c *TableComponent) Render(w io.Writer, result *engine.Result, data *PageDa
 	})
 }
 
+func (c *TableComponent) Stream(w io.Writer, result *StreamResult, data *PageData) error {
+	return c.tmpl.ExecuteTemplate(w, "table.html", struct {
+		Result  *StreamResult
+		Options map[string]string
+	}{
+		Result:  result,
+		Options: result.Query.Options,
+	})
+}
+
 // ListComponent renders data as a list.
 type ListComponent struct {
 	tmpl *template.Template
@@ -58,6 +78,16 @@ func (c *ListComponent) Render(w io.Writer, result *engine.Result, data *PageDat
 	})
 }
 
+func (c *ListComponent) Stream(w io.Writer, result *StreamResult, data *PageData) error {
+	return c.tmpl.ExecuteTemplate(w, "list.html", struct {
+		Result  *StreamResult
+		Options map[string]string
+	}{
+		Result:  result,
+		Options: result.Query.Options,
+	})
+}
+
 // CardComponent renders data as cards.
 type CardComponent struct {
 	tmpl *template.Template
@@ -75,6 +105,16 @@ func (c *CardComponent) Render(w io.Writer, result *engine.Result, data *PageDat
 	})
 }
 
+func (c *CardComponent) Stream(w io.Writer, result *StreamResult, data *PageData) error {
+	return c.tmpl.ExecuteTemplate(w, "card.html", struct {
+		Result  *StreamResult
+		Options map[string]string
+	}{
+		Result:  result,
+		Options: result.Query.Options,
+	})
+}
+
 // ShellComponent renders the page shell (navbar, footer).
 type ShellComponent struct {
 	tmpl *template.Template
@@ -100,12 +140,20 @@ type FormComponent struct {
 func (c *FormComponent) Name() string { return "form" }
 
 func (c *FormComponent) Render(w io.Writer, result *engine.Result, data *PageData) error {
+	// Messages of rejected params, by field name
+	errs := make(map[string]string, len(data.Fields))
+	for _, f := range data.Fields {
+		errs[f.Name] = f.Message
+	}
+
 	return c.tmpl.ExecuteTemplate(w, "form.html", struct {
 		Result  *engine.Result
 		Options map[string]string
+		Errors  map[string]string
 	}{
 		Result:  result,
 		Options: result.Query.Options,
+		Errors:  errs,
 	})
 }
 
@@ -154,6 +202,16 @@ func (c *AlertComponent) Render(w io.Writer, result *engine.Result, data *PageDa
 	})
 }
 
+func (c *AlertComponent) Stream(w io.Writer, result *StreamResult, data *PageData) error {
+	return c.tmpl.ExecuteTemplate(w, "alert.html", struct {
+		Result  *StreamResult
+		Options map[string]string
+	}{
+		Result:  result,
+		Options: result.Query.Options,
+	})
+}
+
 // SSEComponent renders a Server-Sent Events subscriber.
 type SSEComponent struct {
 	tmpl *template.Template
@@ -170,3 +228,22 @@ func (c *SSEComponent) Render(w io.Writer, result *engine.Result, data *PageData
 		Options: result.Query.Options,
 	})
 }
+
+// TemplateComponent renders a components/<name>.html template with no Go
+// counterpart, such as those added with a custom template directory.
+type TemplateComponent struct {
+	name string
+	tmpl *template.Template
+}
+
+func (c *TemplateComponent) Name() string { return c.name }
+
+func (c *TemplateComponent) Render(w io.Writer, result *engine.Result, data *PageData) error {
+	return c.tmpl.ExecuteTemplate(w, c.name+".html", struct {
+		Result  *engine.Result
+		Options map[string]string
+	}{
+		Result:  result,
+		Options: result.Query.Options,
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"io/fs"
 	"log/slog"
+	"path"
 	"strconv"
 	"strings"
 
@@ -31,6 +32,18 @@ type PageData struct {
 	CurrentPath string
 	IsHTMX      bool
 	Error       error
+
+	// Fragment is the id of the fragment requested, rendered without the
+	// element carrying its id so that HTMX swaps it into that element
+	Fragment string
+
+	// Fields lists the params rejected by validation, shown by forms next
+	// to the inputs of the same name
+	Fields []engine.FieldError
+
+	// Shell is the result of the page's shell query, if any, for the
+	// layout: its options (title...) and rows
+	Shell *engine.Result
 }
 
 // Renderer manages component rendering.
@@ -44,6 +57,10 @@ type Renderer struct {
 type Config struct {
 	TemplatesFS fs.FS
 	Logger      *slog.Logger
+
+	// OverrideFS holds templates replacing or adding to those of
+	// TemplatesFS, in the same layouts/, components/ and system/ layout
+	OverrideFS fs.FS
 }
 
 // New creates a new renderer.
@@ -67,6 +84,20 @@ func New(cfg Config) (*Renderer, error) {
 		}
 	}
 
+	// Overrides are parsed last: a template with the same file name (or
+	// a {{define}} with the same name) replaces the embedded one
+	if cfg.OverrideFS != nil {
+		for _, pattern := range patterns {
+			matches, err := fs.Glob(cfg.OverrideFS, pattern)
+			if err != nil || len(matches) == 0 {
+				continue
+			}
+			if _, err := tmpl.ParseFS(cfg.OverrideFS, matches...); err != nil {
+				return nil, fmt.Errorf("parse templates %s: %w", pattern, err)
+			}
+		}
+	}
+
 	r := &Renderer{
 		templates:  tmpl,
 		components: make(map[string]Component),
@@ -85,6 +116,20 @@ func New(cfg Config) (*Renderer, error) {
 	r.Register(&AlertComponent{tmpl: tmpl})
 	r.Register(&SSEComponent{tmpl: tmpl})
 
+	// Any other components/<name>.html is a component named <name>
+	for _, fsys := range []fs.FS{cfg.TemplatesFS, cfg.OverrideFS} {
+		if fsys == nil {
+			continue
+		}
+		matches, _ := fs.Glob(fsys, "components/*.html")
+		for _, match := range matches {
+			name := strings.TrimSuffix(path.Base(match), ".html")
+			if _, ok := r.components[name]; !ok {
+				r.Register(&TemplateComponent{name: name, tmpl: tmpl})
+			}
+		}
+	}
+
 	return r, nil
 }
 
@@ -93,20 +138,20 @@ func (r *Renderer) Register(c Component) {
 	r.components[c.Name()] = c
 }
 
+// Has reports whether a component is registered with that name.
+func (r *Renderer) Has(name string) bool {
+	_, ok := r.components[name]
+	return ok
+}
+
 // RenderPage renders a full page with all results.
 func (r *Renderer) RenderPage(w io.Writer, data *PageData) error {
 	var content bytes.Buffer
 
 	// Render each result with its component
 	for _, result := range data.Results {
-		component, ok := r.components[result.Query.Component]
-		if !ok {
-			r.logger.Warn("unknown component", "name", result.Query.Component)
-			component = r.components["text"]
-		}
-
-		if err := component.Render(&content, result, data); err != nil {
-			return fmt.Errorf("render %s: %w", result.Query.Component, err)
+		if err := r.renderResult(&content, result, data); err != nil {
+			return err
 		}
 	}
 
@@ -128,6 +173,61 @@ func (r *Renderer) RenderPage(w io.Writer, data *PageData) error {
 	return r.templates.ExecuteTemplate(w, "base.html", layoutData)
 }
 
+// renderResult renders a result with its component, in its wrapper
+// element if it needs one.
+func (r *Renderer) renderResult(w io.Writer, result *engine.Result, data *PageData) error {
+	component, ok := r.components[result.Query.Component]
+	if !ok {
+		r.logger.Warn("unknown component", "name", result.Query.Component)
+		component = r.components["text"]
+	}
+
+	open := wrapper(result, data)
+	if _, err := io.WriteString(w, open); err != nil {
+		return err
+	}
+	if err := component.Render(w, result, data); err != nil {
+		return fmt.Errorf("render %s: %w", result.Query.Component, err)
+	}
+	if open != "" {
+		_, err := io.WriteString(w, "</div>")
+		return err
+	}
+	return nil
+}
+
+// wrapper returns the opening tag of the element a result renders in, or
+// "" when it needs none. Queries with an id render inside an element HTMX
+// can target, except when that fragment is rendered on its own. With
+// oob=true target="#id" the output is swapped out of band into the
+// element with that id; another oob value (innerHTML, beforeend...) is
+// the swap strategy applied to the target selector.
+func wrapper(result *engine.Result, data *PageData) string {
+	id := result.Query.Options["id"]
+	oob := result.Query.Options["oob"]
+	if oob != "" && oob != "false" {
+		target := result.Query.Options["target"]
+		if target == "" && id != "" {
+			target = "#" + id
+		}
+		if target == "" {
+			return ""
+		}
+		if oob == "true" && strings.HasPrefix(target, "#") {
+			return fmt.Sprintf(`<div id="%s" hx-swap-oob="true">`, template.HTMLEscapeString(target[1:]))
+		}
+		if oob == "true" {
+			oob = "outerHTML"
+		}
+		return fmt.Sprintf(`<div hx-swap-oob="%s">`, template.HTMLEscapeString(oob+":"+target))
+	}
+
+	if id != "" && id != data.Fragment {
+		return fmt.Sprintf(`<div id="%s">`, template.HTMLEscapeString(id))
+	}
+	return ""
+}
+
 // RenderError renders an error page.
 func (r *Renderer) RenderError(w io.Writer, data *PageData) error {
 	errComponent := r.components["error"]
@@ -186,6 +286,18 @@ var templateFuncs = template.FuncMap{
 		}
 		return false
 	},
+	"fieldError": func(errs map[string]string, name interface{}) string {
+		s, _ := name.(string)
+		return errs[s]
+	},
+	"hasFile": func(rows []map[string]interface{}) bool {
+		for _, row := range rows {
+			if t, ok := row["type"].(string); ok && t == "file" {
+				return true
+			}
+		}
+		return false
+	},
 	// Math functions for pagination
 	"atoi": func(s interface{}) int {
 		switch v := s.(type) {
@@ -0,0 +1,345 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"iter"
+	"strings"
+
+	"github.com/hazyhaar/gopage/pkg/engine"
+)
+
+// contentMarker stands for the page content when the layout is split
+// around it.
+const contentMarker = "<!--gopage:content-->"
+
+// flushRows is the number of rows a streamed component renders between
+// two flushes.
+const flushRows = 100
+
+// StreamComponent is a component that can render rows as they are
+// stepped: its template ranges over them once, in order.
+type StreamComponent interface {
+	Component
+
+	// Stream writes the component HTML for result, whose rows are
+	// received while it ranges over them.
+	Stream(w io.Writer, result *StreamResult, data *PageData) error
+}
+
+// StreamResult is a result whose rows are stepped as the template ranges
+// over them, never collected. It is only used for queries with rows, so
+// that a template testing Rows renders its "no data" case from a Result.
+type StreamResult struct {
+	Query   engine.Query
+	Columns []string
+	Rows    iter.Seq[map[string]interface{}]
+}
+
+// PageWriter renders a page as its queries run, so that the layout head
+// can be sent before the first query and each component as its rows are
+// stepped, instead of once the whole page is built. The rest of the
+// layout is written by Close.
+type PageWriter struct {
+	r       *Renderer
+	w       io.Writer
+	out     *pageOutput
+	flush   func() error
+	data    *PageData
+	foot    string
+	started bool
+
+	// open is the component being streamed, if any
+	open *componentWriter
+}
+
+// NewPageWriter returns a PageWriter rendering data's page to w, calling
+// flush to send what was written so far. Results are passed to Render
+// and Component rather than in data.Results.
+func (r *Renderer) NewPageWriter(w io.Writer, flush func() error, data *PageData) *PageWriter {
+	out := &pageOutput{w: w}
+	return &PageWriter{r: r, w: out, out: out, flush: flush, data: data}
+}
+
+// pageOutput records whether anything was written through it.
+type pageOutput struct {
+	w       io.Writer
+	written bool
+}
+
+func (o *pageOutput) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		o.written = true
+	}
+	return o.w.Write(b)
+}
+
+// Streams reports whether the component with that name renders rows as
+// they are stepped.
+func (r *Renderer) Streams(name string) bool {
+	_, ok := r.components[name].(StreamComponent)
+	return ok
+}
+
+// Started reports whether anything was written yet, committing the
+// response: a fragment without layout only is with its first component.
+func (p *PageWriter) Started() bool {
+	return p.out.written
+}
+
+// Start writes the layout up to its content and flushes it, unless
+// already done.
+func (p *PageWriter) Start() error {
+	if p.started {
+		return nil
+	}
+	if err := p.start(); err != nil {
+		return err
+	}
+	return p.send()
+}
+
+// Render writes a collected result with its component. A shell result is
+// not rendered: until the page is started it is passed to the layout,
+// and starts it.
+func (p *PageWriter) Render(result *engine.Result) error {
+	if result.Query.Component == "shell" {
+		if p.started {
+			return nil
+		}
+		p.data.Shell = result
+		if title, ok := result.Query.Options["title"]; ok {
+			p.data.Title = title
+		}
+		return p.Start()
+	}
+	if err := p.start(); err != nil {
+		return err
+	}
+	if err := p.r.renderResult(p.w, result, p.data); err != nil {
+		return err
+	}
+	return p.send()
+}
+
+// Component returns the writer streaming the rows of query to its
+// component, which must be one that Streams.
+func (p *PageWriter) Component(query engine.Query) (engine.RowWriter, error) {
+	if err := p.start(); err != nil {
+		return nil, err
+	}
+	component, ok := p.r.components[query.Component].(StreamComponent)
+	if !ok {
+		return nil, fmt.Errorf("component %q can't be streamed", query.Component)
+	}
+	p.open = &componentWriter{page: p, component: component, query: query}
+	return p.open, nil
+}
+
+// Error writes the error component in place of the rest of the page, for
+// errors happening once the page is on its way. The component being
+// streamed, if any, is ended first.
+func (p *PageWriter) Error(err error) error {
+	if err := p.start(); err != nil {
+		return err
+	}
+	if p.open != nil {
+		p.open.end()
+		p.open = nil
+	}
+	p.data.Error = err
+	if err := p.r.components["error"].Render(p.w, nil, p.data); err != nil {
+		return err
+	}
+	return p.send()
+}
+
+// Close writes the end of the layout.
+func (p *PageWriter) Close() error {
+	if err := p.start(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(p.w, p.foot)
+	return err
+}
+
+// send flushes what was written so far, if anything: flushing an empty
+// response would commit its status.
+func (p *PageWriter) send() error {
+	if !p.out.written {
+		return nil
+	}
+	return p.flush()
+}
+
+// start writes the layout up to its content, unless already done or the
+// page is rendered without layout.
+func (p *PageWriter) start() error {
+	if p.started {
+		return nil
+	}
+	p.started = true
+	if p.data.IsHTMX {
+		return nil
+	}
+
+	head, foot, err := p.r.layout(p.data)
+	if err != nil {
+		return err
+	}
+	p.foot = foot
+	_, err = io.WriteString(p.w, head)
+	return err
+}
+
+// componentWriter renders the rows of a query with its component as they
+// are stepped. The template runs as a coroutine: it is resumed for each
+// row and suspended when its range asks for the next one, so that it
+// only writes while the query waits for it.
+type componentWriter struct {
+	page      *PageWriter
+	component StreamComponent
+	query     engine.Query
+	columns   []string
+
+	// next resumes the template, until it asks for a row or ends, and
+	// suspend, called by the template, waits for the next row
+	next    func() (struct{}, bool)
+	stop    func()
+	suspend func() bool
+
+	// row is the row handed to the template, ended set once there is none
+	row   map[string]interface{}
+	ended bool
+	rows  int
+
+	// wrapper closes the element the component renders in, if any
+	wrapper bool
+	err     error
+}
+
+// Columns records the columns of the rows.
+func (c *componentWriter) Columns(columns []string) error {
+	c.columns = columns
+	return nil
+}
+
+// Row passes a row to the template, starting it with the first one.
+func (c *componentWriter) Row(values []interface{}) error {
+	if c.next == nil {
+		if err := c.start(); err != nil {
+			return err
+		}
+	}
+
+	c.row = make(map[string]interface{}, len(values))
+	for i, v := range values {
+		c.row[c.columns[i]] = v
+	}
+	if _, ok := c.next(); !ok && c.err != nil {
+		return c.err
+	}
+
+	c.rows++
+	if c.rows%flushRows == 0 {
+		return c.page.send()
+	}
+	return nil
+}
+
+// start writes the opening of the component's wrapper element and runs
+// its template up to the first row it asks for.
+func (c *componentWriter) start() error {
+	result := &StreamResult{
+		Query:   c.query,
+		Columns: c.columns,
+		Rows: func(yield func(map[string]interface{}) bool) {
+			for c.suspend() && !c.ended {
+				if !yield(c.row) {
+					return
+				}
+			}
+		},
+	}
+
+	open := wrapper(&engine.Result{Query: c.query}, c.page.data)
+	if _, err := io.WriteString(c.page.w, open); err != nil {
+		return err
+	}
+	c.wrapper = open != ""
+
+	c.next, c.stop = iter.Pull(func(suspend func(struct{}) bool) {
+		c.suspend = func() bool { return suspend(struct{}{}) }
+		if err := c.component.Stream(c.page.w, result, c.page.data); err != nil {
+			c.err = fmt.Errorf("render %s: %w", c.query.Component, err)
+		}
+	})
+	c.next()
+	return c.err
+}
+
+// Close ends the rows and lets the template write the rest of the
+// component; a query without rows is rendered like a collected one.
+func (c *componentWriter) Close() error {
+	if c.page.open == c {
+		c.page.open = nil
+	}
+	if c.next == nil {
+		result := &engine.Result{Query: c.query, Columns: c.columns, Rows: []map[string]interface{}{}}
+		if err := c.page.r.renderResult(c.page.w, result, c.page.data); err != nil {
+			return err
+		}
+		return c.page.send()
+	}
+
+	if err := c.end(); err != nil {
+		return err
+	}
+	return c.page.send()
+}
+
+// end lets a started template write the rest of the component, and
+// closes its wrapper element.
+func (c *componentWriter) end() error {
+	if c.next == nil {
+		return nil
+	}
+
+	// A template ranging over the rows again gets none
+	c.ended = true
+	for {
+		if _, ok := c.next(); !ok {
+			break
+		}
+	}
+	c.stop()
+	if c.err != nil {
+		return c.err
+	}
+	if c.wrapper {
+		_, err := io.WriteString(c.page.w, "</div>")
+		return err
+	}
+	return nil
+}
+
+// layout renders the layout of data's page and splits it around its
+// content. A layout without content is all head.
+func (r *Renderer) layout(data *PageData) (head, foot string, err error) {
+	layoutData := struct {
+		*PageData
+		Content template.HTML
+	}{
+		PageData: data,
+		Content:  contentMarker,
+	}
+
+	var buf bytes.Buffer
+	if err := r.templates.ExecuteTemplate(&buf, "base.html", layoutData); err != nil {
+		return "", "", err
+	}
+	head, foot, _ = strings.Cut(buf.String(), contentMarker)
+	return head, foot, nil
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// denyAccess answers a request rejected by an authenticate query.
+// Browsers are redirected to the "redirect" option when it is set; HTMX and
+// JSON requests, and pages without a redirect, get the "status" option (401
+// by default, e.g. status=403) with the "message" option as error text.
+//
+//	-- @query component=authenticate redirect=/login status=403
+//	SELECT u.id, u.role FROM sessions s JOIN users u ON u.id = s.user_id
+//	WHERE s.id = $_cookie_session_id;
+func (s *Server) denyAccess(w http.ResponseWriter, r *http.Request, opts map[string]string) {
+	isHTMX := r.Header.Get("HX-Request") == "true"
+
+	if target := opts["redirect"]; target != "" && !isHTMX && !wantsJSON(r) {
+		http.Redirect(w, r, target, http.StatusSeeOther)
+		return
+	}
+
+	status := http.StatusUnauthorized
+	if v, err := strconv.Atoi(opts["status"]); err == nil && v >= 400 {
+		status = v
+	}
+	message := opts["message"]
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	s.renderError(w, r, status, message)
+}
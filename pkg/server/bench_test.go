@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/hazyhaar/gopage/internal/templates"
+	"github.com/hazyhaar/gopage/pkg/db"
+	"github.com/hazyhaar/gopage/pkg/funcs"
+	"github.com/hazyhaar/gopage/pkg/migrate"
+	"github.com/hazyhaar/gopage/pkg/render"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// benchSeed adds a topic with replies to the forum schema.
+const benchSeed = `
+INSERT INTO forum_sessions (id, user_id, expires_at) VALUES ('bench', 1, datetime('now', '+1 day'));
+INSERT INTO forum_topics (id, category_id, user_id, title, slug, content)
+VALUES (1, 2, 1, 'Benchmark', 'benchmark', 'Topic used by the page benchmarks');
+WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 20)
+INSERT INTO forum_posts (topic_id, user_id, content) SELECT 1, 1, 'Reply ' || i FROM n;
+`
+
+// newBenchServer serves the forum of the repository's sql directory from a
+// fresh database with the demo data.
+func newBenchServer(b *testing.B) *Server {
+	b.Helper()
+	sqlDir, err := filepath.Abs("../../sql")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	database, err := db.Open(db.Config{Path: filepath.Join(b.TempDir(), "bench.db")})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { database.Close() })
+	if err := database.SetConnInit(funcs.New().Apply); err != nil {
+		b.Fatal(err)
+	}
+
+	migrations, err := migrate.Load(filepath.Join(sqlDir, "_migrations"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	conn, release, err := database.Writer(context.Background())
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := migrate.Apply(conn, migrations); err != nil {
+		b.Fatal(err)
+	}
+	if err := migrate.Seed(conn, filepath.Join(sqlDir, "_seed", "demo.sql")); err != nil {
+		b.Fatal(err)
+	}
+	err = sqlitex.ExecuteScript(conn, benchSeed, nil)
+	release()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	templateFS, err := fs.Sub(templates.FS, "files")
+	if err != nil {
+		b.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	renderer, err := render.New(render.Config{TemplatesFS: templateFS, Logger: logger})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return New(Config{DB: database, Renderer: renderer, SQLDir: sqlDir, Logger: logger})
+}
+
+// benchModes are the caches used by the page benchmarks: compiled pages
+// and prepared statements, each reset or disabled on its own and together.
+var benchModes = []struct {
+	name  string
+	pages bool
+	stmts bool
+}{
+	{"cached", true, true},
+	{"uncached-pages", false, true},
+	{"uncached-stmts", true, false},
+	{"uncached", false, false},
+}
+
+// BenchmarkForumPages measures forum page requests with the compiled page
+// cache and the statement cache, and without them: every request parses
+// and classifies the page, and prepares its statements for a single use.
+func BenchmarkForumPages(b *testing.B) {
+	s := newBenchServer(b)
+	pages := []struct {
+		name string
+		url  string
+	}{
+		{"index", "/forum/index"},
+		{"category", "/forum/category?slug=discussions"},
+		{"topic", "/forum/topic?id=1"},
+		{"search", "/forum/search?q=Bench"},
+	}
+
+	for _, page := range pages {
+		for _, mode := range benchModes {
+			b.Run(page.name+"/"+mode.name, func(b *testing.B) {
+				s.executor.StatementCacheSize = 0
+				if !mode.stmts {
+					s.executor.StatementCacheSize = -1
+				}
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if !mode.pages {
+						s.pages = newPageCache()
+					}
+					r := httptest.NewRequest(http.MethodGet, page.url, nil)
+					r.AddCookie(&http.Cookie{Name: "session_id", Value: "bench"})
+					w := httptest.NewRecorder()
+					s.handlePage(w, r)
+					if w.Code != http.StatusOK {
+						b.Fatalf("%s: status %d", page.url, w.Code)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hazyhaar/gopage/pkg/engine"
+)
+
+// setCookies sets one cookie per row of a cookie component.
+//
+// Columns (or query options, used as defaults for every row):
+//
+//	name       cookie name (required)
+//	value      cookie value; NULL deletes the cookie
+//	max_age    lifetime in seconds; NULL for a session cookie, <= 0 deletes
+//	path       defaults to "/"
+//	domain     optional
+//	http_only  defaults to true
+//	secure     defaults to true when the request came over TLS
+//	same_site  lax (default), strict or none
+//
+// Example:
+//
+//	-- @query component=cookie
+//	SELECT 'session_id' AS name, $token AS value, 86400 AS max_age;
+func setCookies(w http.ResponseWriter, r *http.Request, result *engine.Result) {
+	for _, row := range result.Rows {
+		get := func(key string) (string, bool) {
+			if v, ok := row[key]; ok {
+				if v == nil {
+					return "", false
+				}
+				return fmt.Sprint(v), true
+			}
+			v, ok := result.Query.Options[key]
+			return v, ok
+		}
+
+		name, _ := get("name")
+		if name == "" {
+			continue
+		}
+
+		cookie := &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		}
+
+		value, hasValue := get("value")
+		cookie.Value = value
+
+		if v, ok := get("max_age"); ok {
+			maxAge, err := strconv.Atoi(v)
+			if err == nil {
+				if maxAge <= 0 {
+					hasValue = false
+				} else {
+					cookie.MaxAge = maxAge
+				}
+			}
+		}
+		if !hasValue {
+			cookie.Value = ""
+			cookie.MaxAge = -1
+		}
+
+		if v, ok := get("path"); ok && v != "" {
+			cookie.Path = v
+		}
+		if v, ok := get("domain"); ok {
+			cookie.Domain = v
+		}
+		if v, ok := get("http_only"); ok {
+			cookie.HttpOnly = isTruthy(v)
+		}
+		if v, ok := get("secure"); ok {
+			cookie.Secure = isTruthy(v)
+		}
+		if v, ok := get("same_site"); ok {
+			switch strings.ToLower(v) {
+			case "strict":
+				cookie.SameSite = http.SameSiteStrictMode
+			case "none":
+				cookie.SameSite = http.SameSiteNoneMode
+			default:
+				cookie.SameSite = http.SameSiteLaxMode
+			}
+		}
+
+		http.SetCookie(w, cookie)
+	}
+}
+
+// isTruthy interprets SQL-ish boolean values (1, true, yes, on).
+func isTruthy(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
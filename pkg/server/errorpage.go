@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hazyhaar/gopage/pkg/engine"
+)
+
+// errorPageName is the custom error page used for any status without a
+// more specific _<status>.sql page.
+const errorPageName = "_error.sql"
+
+// errorPagePath returns the custom error page for a request to urlPath
+// failing with status, or "" if there is none. Each directory from the
+// page's own up to the SQL root is searched for _<status>.sql, then
+// _error.sql; the closest match wins.
+func (s *Server) errorPagePath(urlPath string, status int) string {
+	urlPath = strings.TrimSuffix(urlPath, ".json")
+	urlPath = strings.TrimSuffix(urlPath, ".sql")
+	dir := path.Dir(path.Clean("/" + urlPath))
+
+	names := []string{fmt.Sprintf("_%d.sql", status), errorPageName}
+	for {
+		for _, name := range names {
+			p := filepath.Join(s.sqlDir, filepath.FromSlash(dir), name)
+			if fileExists(p) {
+				return p
+			}
+		}
+		if dir == "/" {
+			return ""
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// renderCustomError renders pageErr with a custom error page written in
+// SQL, executed with the request params plus $_error_status,
+// $_error_message and, for rejected params, $_error_field_<name> and
+// $_error_fields. Error pages run behind the guards of their directory
+// like any page. It reports false when there is no such page, its guards
+// deny the request or it failed, so that the built-in error template is
+// used instead.
+func (s *Server) renderCustomError(w http.ResponseWriter, r *http.Request, pageErr *PageError) bool {
+	sqlPath := s.errorPagePath(r.URL.Path, pageErr.Status)
+	if sqlPath == "" {
+		return false
+	}
+
+	file, err := s.parse(sqlPath)
+	if err != nil {
+		s.logger.Error("parse error page", "path", sqlPath, "error", err)
+		return false
+	}
+	guards, err := s.parseGuards(sqlPath)
+	if err != nil {
+		s.logger.Error("parse guard error", "path", sqlPath, "error", err)
+		return false
+	}
+
+	params := requestParams(r, nil)
+	params["_error_status"] = strconv.Itoa(pageErr.Status)
+	params["_error_message"] = pageErr.Message
+	if len(pageErr.Fields) > 0 {
+		fields := make([]jsonFieldError, len(pageErr.Fields))
+		for i, f := range pageErr.Fields {
+			fields[i] = jsonFieldError{Name: f.Name, Message: f.Message}
+			params["_error_field_"+f.Name] = f.Message
+		}
+		encoded, err := json.Marshal(fields)
+		if err != nil {
+			s.logger.Error("encode field errors", "error", err)
+			return false
+		}
+		params["_error_fields"] = string(encoded)
+	}
+
+	results, err := s.runErrorPage(r.Context(), guards, file, params)
+	if errors.Is(err, errGuardDenied) {
+		s.logger.Debug("error page denied by its guards", "path", sqlPath)
+		return false
+	}
+	if err != nil {
+		s.logger.Error("error page failed", "path", sqlPath, "error", err)
+		return false
+	}
+
+	s.writeResults(w, r, pageErr.Status, results, pageErr.Fields)
+	return true
+}
+
+// errGuardDenied is returned by runErrorPage when a guard denies access to
+// the error page.
+var errGuardDenied = errors.New("denied by guard")
+
+// runErrorPage executes an error page after its guards, on the writer
+// inside a transaction if any of them writes (e.g. to log the error).
+func (s *Server) runErrorPage(ctx context.Context, guards []*engine.File, file *engine.File, params engine.Params) (results []*engine.Result, err error) {
+	if files := append(guards, file); unclassified(files) {
+		reader, releaseReader, err := s.db.Reader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		files, err = s.classify(reader, files)
+		releaseReader()
+		if err != nil {
+			return nil, err
+		}
+		guards, file = files[:len(guards)], files[len(guards)]
+	}
+
+	if timeout := s.pageTimeout(file, false); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	write := false
+	for _, f := range append(guards, file) {
+		if f.Writes() {
+			write = true
+		}
+	}
+	conn, release, err := s.conn(ctx, write)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if write {
+		if err := execTransient(conn, "BEGIN IMMEDIATE"); err != nil {
+			rollback(conn)
+			return nil, err
+		}
+		defer func() {
+			if err == nil {
+				err = execTransient(conn, "COMMIT")
+			}
+			if err != nil {
+				rollback(conn)
+			}
+		}()
+	}
+
+	params, denied, err := s.runGuards(ctx, conn, guards, params)
+	var aerr *engine.AuthError
+	if denied != "" || errors.As(err, &aerr) {
+		return nil, errGuardDenied
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.executor.ExecuteFile(ctx, conn, file, params)
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hazyhaar/gopage/pkg/engine"
+	"zombiezen.com/go/sqlite"
+)
+
+// exportFormat describes a download format.
+type exportFormat struct {
+	contentType string
+	ext         string
+}
+
+// exportFormats are the formats accepted by the download component and
+// the ?_format= override.
+var exportFormats = map[string]exportFormat{
+	"csv":    {contentType: "text/csv; charset=utf-8", ext: ".csv"},
+	"tsv":    {contentType: "text/tab-separated-values; charset=utf-8", ext: ".tsv"},
+	"ndjson": {contentType: "application/x-ndjson", ext: ".ndjson"},
+}
+
+// errNothingExported is returned when ?_format= asks for an export but no
+// query of the page was exported, e.g. all are in @if branches not taken.
+var errNothingExported = errors.New("no query exported")
+
+// flushEvery is the number of rows written between two flushes.
+const flushEvery = 1000
+
+// exportQuery returns which queries of file are streamed as a download,
+// and the format forced by ?_format= if any, or a nil match when the
+// request renders the page normally. Download queries are exported or,
+// with ?_format= and no download query, table queries; the first of them
+// that runs is streamed.
+func exportQuery(file *engine.File, r *http.Request) (match func(engine.Query) bool, format string, err error) {
+	format = r.URL.Query().Get("_format")
+	if format != "" {
+		if _, ok := exportFormats[format]; !ok {
+			return nil, "", fmt.Errorf("unknown export format %q", format)
+		}
+	}
+
+	component := ""
+	for _, q := range file.Queries {
+		switch {
+		case q.Component == "download":
+			component = "download"
+			if f := q.Options["format"]; f != "" {
+				if _, ok := exportFormats[f]; !ok {
+					return nil, "", fmt.Errorf("unknown export format %q", f)
+				}
+			}
+		case q.Component == "table" && format != "" && component == "":
+			component = "table"
+		}
+	}
+	if component == "" && format != "" {
+		return nil, "", fmt.Errorf("page has no table to export")
+	}
+	if component == "" {
+		return nil, "", nil
+	}
+	return func(q engine.Query) bool { return q.Component == component }, format, nil
+}
+
+// exportFilename returns the attachment name: the filename option, or
+// the SQL file's base name, with the format's extension.
+func exportFilename(file *engine.File, query engine.Query, format string) string {
+	name := query.Options["filename"]
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(file.Path), ".sql")
+		if suffix := methodSuffix(name); suffix != "" {
+			name = strings.TrimSuffix(name, "."+suffix)
+		}
+	}
+	ext := exportFormats[format].ext
+	if filepath.Ext(name) == "" {
+		name += ext
+	}
+	return name
+}
+
+// export runs file until the first query selected by match, whose rows
+// are streamed to w in format, or the query's own format when empty. When
+// commit is set, the page's writes are committed once the query prepared,
+// before it runs, so that an export never shows rows that could still be
+// rolled back, and a query that fails to prepare rolls them back. Response
+// headers are only sent then, so an error returned before rw.started can
+// still be reported as an error page. rw is nil when no query was
+// exported: results then hold those of the whole page.
+func (s *Server) export(ctx context.Context, w http.ResponseWriter, conn *sqlite.Conn, file *engine.File, match func(engine.Query) bool, format string, params engine.Params, commit bool) (results []*engine.Result, rw *exportWriter, err error) {
+	results, _, err = s.executor.ExecuteExport(ctx, conn, file, params, &engine.Export{
+		Match: match,
+		Start: func(query engine.Query) (engine.RowWriter, error) {
+			if commit {
+				if err := execTransient(conn, "COMMIT"); err != nil {
+					return nil, fmt.Errorf("commit transaction: %w", err)
+				}
+			}
+			format := format
+			if format == "" {
+				format = query.Options["format"]
+			}
+			if format == "" {
+				format = "csv"
+			}
+			rw = &exportWriter{
+				w:        w,
+				format:   format,
+				filename: exportFilename(file, query, format),
+			}
+			return rw, nil
+		},
+	})
+	if err != nil && rw != nil && rw.started {
+		// Rows written before the error still reach the client
+		rw.flush()
+	}
+	return results, rw, err
+}
+
+// exportWriter writes streamed rows to an HTTP response.
+type exportWriter struct {
+	w        http.ResponseWriter
+	format   string
+	filename string
+	started  bool
+
+	columns []string
+	csv     *csv.Writer
+	rows    int
+}
+
+// Columns sends the response headers and the CSV/TSV header line.
+func (e *exportWriter) Columns(columns []string) error {
+	e.columns = columns
+	e.started = true
+
+	h := e.w.Header()
+	h.Set("Content-Type", exportFormats[e.format].contentType)
+	h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.filename))
+	e.w.WriteHeader(http.StatusOK)
+
+	switch e.format {
+	case "csv", "tsv":
+		e.csv = csv.NewWriter(e.w)
+		if e.format == "tsv" {
+			e.csv.Comma = '\t'
+		}
+		return e.csv.Write(columns)
+	}
+	return nil
+}
+
+// Row writes one row, flushing the response every flushEvery rows.
+func (e *exportWriter) Row(values []interface{}) error {
+	var err error
+	if e.csv != nil {
+		record := make([]string, len(values))
+		for i, v := range values {
+			record[i] = exportValue(v)
+		}
+		err = e.csv.Write(record)
+	} else {
+		err = e.writeJSON(values)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%flushEvery == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+// writeJSON writes a row as a JSON object, keeping the column order.
+func (e *exportWriter) writeJSON(values []interface{}) error {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(e.columns[i])
+		b.Write(key)
+		b.WriteByte(':')
+		val, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		b.Write(val)
+	}
+	b.WriteString("}\n")
+	_, err := e.w.Write([]byte(b.String()))
+	return err
+}
+
+// Close flushes the last rows.
+func (e *exportWriter) Close() error {
+	return e.flush()
+}
+
+// flush pushes buffered rows to the client.
+func (e *exportWriter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if f, ok := e.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// exportValue formats a column value for CSV/TSV; NULL is an empty field.
+func exportValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
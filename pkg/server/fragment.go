@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/hazyhaar/gopage/pkg/engine"
+)
+
+// fragmentID returns the fragment a request asks for: ?_fragment=<id>, or
+// the id of the element an HTMX request targets (its HX-Target header).
+func fragmentID(r *http.Request) string {
+	if id := r.URL.Query().Get("_fragment"); id != "" {
+		return id
+	}
+	if r.Header.Get("HX-Request") == "true" {
+		return r.Header.Get("HX-Target")
+	}
+	return ""
+}
+
+// pageFragment returns the part of file to run for a request: only the
+// queries of the requested fragment, or the whole file when none is asked
+// for. An HX-Target matching no query renders the whole page as usual, but
+// an unknown ?_fragment= reports false.
+func pageFragment(file *engine.File, r *http.Request) (*engine.File, bool) {
+	id := fragmentID(r)
+	if id == "" {
+		return file, true
+	}
+	if fragment, ok := file.Fragment(id); ok {
+		return fragment, true
+	}
+	return file, r.URL.Query().Get("_fragment") == ""
+}
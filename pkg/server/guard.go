@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/hazyhaar/gopage/pkg/engine"
+	"zombiezen.com/go/sqlite"
+)
+
+// guardFileName is the per-directory access control file.
+const guardFileName = "_guard.sql"
+
+// guardPaths returns the _guard.sql files that protect sqlPath, from the
+// SQL root down to the file's own directory.
+func (s *Server) guardPaths(sqlPath string) []string {
+	rel, err := filepath.Rel(s.sqlDir, filepath.Dir(sqlPath))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil
+	}
+
+	dirs := []string{s.sqlDir}
+	if rel != "." {
+		dir := s.sqlDir
+		for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+			dir = filepath.Join(dir, part)
+			dirs = append(dirs, dir)
+		}
+	}
+
+	var paths []string
+	for _, dir := range dirs {
+		if path := filepath.Join(dir, guardFileName); fileExists(path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// parseGuards parses the guards protecting sqlPath.
+func (s *Server) parseGuards(sqlPath string) ([]*engine.File, error) {
+	var guards []*engine.File
+	for _, path := range s.guardPaths(sqlPath) {
+		guard, err := s.parse(path)
+		if err != nil {
+			return nil, err
+		}
+		guards = append(guards, guard)
+	}
+	return guards, nil
+}
+
+// runGuards executes guards in order on conn before the page itself.
+// A guard denies the request when an authenticate query returns no row
+// (an *engine.AuthError is returned) or when a redirect query yields a
+// target (returned as redirect). Otherwise the variables produced by the
+// guards ($_user_*, -- @set) are added to the returned params for the page.
+func (s *Server) runGuards(ctx context.Context, conn *sqlite.Conn, guards []*engine.File, params engine.Params) (engine.Params, string, error) {
+	for _, guard := range guards {
+		results, err := s.executor.ExecuteFile(ctx, conn, guard, params)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, result := range results {
+			if result.Query.Component == "redirect" {
+				if target := redirectTarget(result); target != "" {
+					return nil, target, nil
+				}
+			}
+		}
+		params = engine.WithVariables(params, results)
+	}
+	return params, "", nil
+}
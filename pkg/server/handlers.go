@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// handlerMethods lists the HTTP methods that can have a dedicated SQL file,
+// e.g. users.get.sql or users.delete.sql next to the users.sql fallback.
+var handlerMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// isWriteMethod reports whether requests with this method run on the
+// writer connection inside a transaction.
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// methodSuffix returns the method suffix of a handler name ("users.get"
+// -> "get"), or "" if the name has none.
+func methodSuffix(name string) string {
+	ext := strings.TrimPrefix(filepath.Ext(name), ".")
+	for _, m := range handlerMethods {
+		if strings.EqualFold(ext, m) {
+			return strings.ToLower(m)
+		}
+	}
+	return ""
+}
+
+// handlerExists reports whether base (a path without .sql) has a fallback
+// file or at least one per-method file.
+func handlerExists(base string) bool {
+	if fileExists(base + ".sql") {
+		return true
+	}
+	for _, m := range handlerMethods {
+		if fileExists(base + "." + strings.ToLower(m) + ".sql") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveHandler picks the SQL file for method: base.<method>.sql first,
+// then base.sql. HEAD is served by the GET handler. When nothing matches,
+// it returns the methods that do have a handler, for the Allow header.
+func resolveHandler(base, method string) (string, []string) {
+	lookup := method
+	if lookup == http.MethodHead {
+		lookup = http.MethodGet
+	}
+
+	if path := base + "." + strings.ToLower(lookup) + ".sql"; fileExists(path) {
+		return path, nil
+	}
+	if path := base + ".sql"; fileExists(path) {
+		return path, nil
+	}
+
+	var allow []string
+	for _, m := range handlerMethods {
+		if fileExists(base + "." + strings.ToLower(m) + ".sql") {
+			allow = append(allow, m)
+			if m == http.MethodGet {
+				allow = append(allow, http.MethodHead)
+			}
+		}
+	}
+	sort.Strings(allow)
+	return "", allow
+}
+
+// fileExists reports whether path is an existing regular file.
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/hazyhaar/gopage/pkg/engine"
+)
+
+// jsonResult is the JSON form of an engine.Result.
+type jsonResult struct {
+	Component    string                   `json:"component"`
+	Options      map[string]string        `json:"options"`
+	Columns      []string                 `json:"columns"`
+	Rows         []map[string]interface{} `json:"rows"`
+	RowsAffected int64                    `json:"rows_affected"`
+}
+
+// jsonError is the JSON form of a PageError.
+type jsonError struct {
+	Error struct {
+		Status  int              `json:"status"`
+		Message string           `json:"message"`
+		Fields  []jsonFieldError `json:"fields,omitempty"`
+	} `json:"error"`
+}
+
+type jsonFieldError struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+// wantsJSON reports whether the client asked for JSON, either with a
+// .json suffix on the path or with an Accept header.
+func wantsJSON(r *http.Request) bool {
+	if strings.HasSuffix(r.URL.Path, ".json") {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// renderJSON writes page results as JSON.
+func (s *Server) renderJSON(w http.ResponseWriter, status int, results []*engine.Result) {
+	out := make([]jsonResult, 0, len(results))
+	for _, result := range results {
+		out = append(out, jsonResult{
+			Component:    result.Query.Component,
+			Options:      result.Query.Options,
+			Columns:      result.Columns,
+			Rows:         result.Rows,
+			RowsAffected: result.RowsAffected,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		s.logger.Error("render json error", "error", err)
+	}
+}
+
+// renderJSONError writes a PageError as JSON.
+func (s *Server) renderJSONError(w http.ResponseWriter, pageErr *PageError) {
+	var out jsonError
+	out.Error.Status = pageErr.Status
+	out.Error.Message = pageErr.Message
+	for _, f := range pageErr.Fields {
+		out.Error.Fields = append(out.Error.Fields, jsonFieldError{Name: f.Name, Message: f.Message})
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(pageErr.Status)
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		s.logger.Error("render json error", "error", err)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hazyhaar/gopage/pkg/engine"
+	"zombiezen.com/go/sqlite"
+)
+
+// pageCache holds the compiled SQL files of pages, guards and error pages:
+// parsed, then classified on first use. An entry is reused until its file
+// or one of the files it includes changes on disk. Cached files are shared
+// by concurrent requests and never modified; classify caches a classified
+// copy in place of the parsed file.
+type pageCache struct {
+	mu      sync.Mutex
+	entries map[string]*pageEntry
+}
+
+// pageEntry is a compiled file and the versions of the files it was
+// built from.
+type pageEntry struct {
+	file    *engine.File
+	sources map[string]fileStamp
+}
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func newPageCache() *pageCache {
+	return &pageCache{entries: make(map[string]*pageEntry)}
+}
+
+// stamp returns the current version of the file at path.
+func stamp(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// current reports whether none of the entry's files changed.
+func (e *pageEntry) current() bool {
+	for path, version := range e.sources {
+		if v, err := stamp(path); err != nil || v != version {
+			return false
+		}
+	}
+	return true
+}
+
+// parse returns the parsed file at path, from the cache unless the file
+// or one of its includes changed since it was parsed.
+func (s *Server) parse(path string) (*engine.File, error) {
+	s.pages.mu.Lock()
+	entry := s.pages.entries[path]
+	s.pages.mu.Unlock()
+	if entry != nil && entry.current() {
+		return entry.file, nil
+	}
+
+	// Stat before reading, so that a change made meanwhile is seen next time
+	version, err := stamp(path)
+	if err != nil {
+		return s.parser.ParseFile(path)
+	}
+	file, err := s.parser.ParseFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := map[string]fileStamp{path: version}
+	for _, inc := range file.Includes {
+		v, err := stamp(inc)
+		if err != nil {
+			return file, nil
+		}
+		sources[inc] = v
+	}
+
+	s.pages.mu.Lock()
+	s.pages.entries[path] = &pageEntry{file: file, sources: sources}
+	s.pages.mu.Unlock()
+	return file, nil
+}
+
+// unclassified reports whether some of files still need Executor.Classify.
+func unclassified(files []*engine.File) bool {
+	for _, f := range files {
+		if !f.Classified() {
+			return true
+		}
+	}
+	return false
+}
+
+// classify returns files classified on reader. Each file is classified as
+// a copy, which replaces it in the cache so that it is classified once.
+func (s *Server) classify(reader *sqlite.Conn, files []*engine.File) ([]*engine.File, error) {
+	out := make([]*engine.File, len(files))
+	for i, file := range files {
+		if file.Classified() {
+			out[i] = file
+			continue
+		}
+
+		classified := *file
+		classified.Queries = append([]engine.Query(nil), file.Queries...)
+		if err := s.executor.Classify(reader, &classified); err != nil {
+			return nil, err
+		}
+		out[i] = &classified
+
+		s.pages.mu.Lock()
+		if entry := s.pages.entries[file.Path]; entry != nil && entry.file == file {
+			s.pages.entries[file.Path] = &pageEntry{file: &classified, sources: entry.sources}
+		}
+		s.pages.mu.Unlock()
+	}
+	return out, nil
+}
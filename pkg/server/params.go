@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/hazyhaar/gopage/pkg/engine"
+)
+
+// reservedPrefix marks parameters set by the server from the request
+// itself ($_cookie_session_id, $_remote_addr, ...). Query string, form and
+// path values starting with it are dropped so they cannot be spoofed.
+const reservedPrefix = "_"
+
+// nonWordRegex matches characters that can't appear in a parameter name.
+var nonWordRegex = regexp.MustCompile(`\W`)
+
+// requestParams builds the parameters bound to a page: query string and
+// form values, then path segments, then the reserved request variables:
+//
+//	$_method, $_path, $_remote_addr, $_user_agent, $_request_id
+//	$_cookie_<name>   e.g. $_cookie_session_id
+//	$_header_<name>   lowercase, dashes as underscores, e.g. $_header_accept_language
+func requestParams(r *http.Request, pathParams map[string]string) engine.Params {
+	params := make(engine.Params)
+
+	// Build params from URL query and form
+	for key, values := range r.URL.Query() {
+		if len(values) > 0 && !strings.HasPrefix(key, reservedPrefix) {
+			params[key] = values[0]
+		}
+	}
+
+	// Parse form for write requests (POST, PUT, PATCH, DELETE)
+	if isWriteMethod(r.Method) {
+		if err := r.ParseForm(); err == nil {
+			for key, values := range r.Form {
+				if len(values) > 0 && !strings.HasPrefix(key, reservedPrefix) {
+					params[key] = values[0]
+				}
+			}
+		}
+	}
+
+	// Path segments take precedence over query and form values
+	for key, value := range pathParams {
+		if !strings.HasPrefix(key, reservedPrefix) {
+			params[key] = value
+		}
+	}
+
+	// Request context variables
+	params["_method"] = r.Method
+	params["_path"] = r.URL.Path
+	params["_remote_addr"] = remoteAddr(r)
+	params["_user_agent"] = r.UserAgent()
+	if id := middleware.GetReqID(r.Context()); id != "" {
+		params["_request_id"] = id
+	}
+
+	for _, cookie := range r.Cookies() {
+		// The first cookie wins when names collide after sanitizing
+		name := "_cookie_" + nonWordRegex.ReplaceAllString(cookie.Name, "_")
+		if _, exists := params[name]; !exists {
+			params[name] = cookie.Value
+		}
+	}
+
+	for key, values := range r.Header {
+		if len(values) > 0 {
+			name := "_header_" + nonWordRegex.ReplaceAllString(strings.ToLower(key), "_")
+			params[name] = values[0]
+		}
+	}
+
+	return params
+}
+
+// remoteAddr returns the client IP without the port. middleware.RealIP
+// has already replaced RemoteAddr with X-Forwarded-For/X-Real-IP if present.
+func remoteAddr(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
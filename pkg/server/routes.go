@@ -0,0 +1,142 @@
+package server
+
+import (
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// dynamicSegmentRegex matches a bracketed path segment: [name]
+var dynamicSegmentRegex = regexp.MustCompile(`^\[(\w+)\]$`)
+
+// route maps a URL pattern with dynamic segments to a SQL file.
+type route struct {
+	// pattern is the URL pattern, e.g. /forum/topic/[id]
+	pattern string
+
+	// segments are the pattern segments; dynamic ones hold the param name
+	// and have dynamic[i] set
+	segments []string
+	dynamic  []bool
+
+	// file is the handler base path relative to the SQL dir, without
+	// the method suffix and .sql extension
+	file string
+}
+
+// routeTable resolves URL paths to dynamic SQL files such as
+// topic/[id].sql or blog/[year]/[slug].sql.
+// Static files are resolved directly on disk and always win; the table
+// only holds files with at least one bracketed segment.
+type routeTable struct {
+	routes []route
+}
+
+// buildRoutes walks the SQL directory and collects dynamic routes.
+// Files and directories starting with "_" are private and never routed.
+func buildRoutes(sqlDir string) (*routeTable, error) {
+	table := &routeTable{}
+	seen := make(map[string]bool)
+
+	err := filepath.WalkDir(sqlDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != sqlDir && strings.HasPrefix(d.Name(), "_") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || filepath.Ext(path) != ".sql" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(sqlDir, path)
+		if err != nil {
+			return err
+		}
+		rel = strings.TrimSuffix(filepath.ToSlash(rel), ".sql")
+
+		// users.get.sql and users.sql share the route of their base name
+		if suffix := methodSuffix(rel); suffix != "" {
+			rel = strings.TrimSuffix(rel, "."+suffix)
+		}
+		if seen[rel] {
+			return nil
+		}
+		seen[rel] = true
+
+		rt := route{pattern: "/" + rel, file: rel}
+		hasDynamic := false
+		for _, seg := range strings.Split(rel, "/") {
+			if m := dynamicSegmentRegex.FindStringSubmatch(seg); m != nil {
+				rt.segments = append(rt.segments, m[1])
+				rt.dynamic = append(rt.dynamic, true)
+				hasDynamic = true
+			} else {
+				rt.segments = append(rt.segments, seg)
+				rt.dynamic = append(rt.dynamic, false)
+			}
+		}
+		if hasDynamic {
+			table.routes = append(table.routes, rt)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Order routes so that matching is deterministic. Only routes with as
+	// many segments can match the same path: between those, at the first
+	// segment where one is static and the other dynamic, the static one
+	// wins. The pattern breaks the remaining ties.
+	sort.SliceStable(table.routes, func(i, j int) bool {
+		a, b := table.routes[i], table.routes[j]
+		if len(a.segments) != len(b.segments) {
+			return len(a.segments) < len(b.segments)
+		}
+		for k := range a.segments {
+			if a.dynamic[k] != b.dynamic[k] {
+				return !a.dynamic[k]
+			}
+		}
+		return a.pattern < b.pattern
+	})
+
+	return table, nil
+}
+
+// match finds the first route matching path (without .sql suffix) and
+// returns its handler base path and the values of its dynamic segments.
+func (t *routeTable) match(path string) (string, map[string]string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	for _, rt := range t.routes {
+		if len(rt.segments) != len(parts) {
+			continue
+		}
+
+		params := make(map[string]string)
+		matched := true
+		for i, seg := range rt.segments {
+			if rt.dynamic[i] {
+				if parts[i] == "" {
+					matched = false
+					break
+				}
+				params[seg] = parts[i]
+			} else if seg != parts[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return rt.file, params, true
+		}
+	}
+	return "", nil, false
+}
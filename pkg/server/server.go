@@ -3,11 +3,13 @@ package server
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -15,7 +17,6 @@ import (
 	"github.com/hazyhaar/gopage/pkg/engine"
 	"github.com/hazyhaar/gopage/pkg/render"
 	"github.com/hazyhaar/gopage/pkg/sse"
-	"zombiezen.com/go/sqlite"
 )
 
 // Server is the GoPage HTTP server.
@@ -23,10 +24,16 @@ type Server struct {
 	router   *chi.Mux
 	db       *db.DB
 	parser   *engine.Parser
+	pages    *pageCache
 	executor *engine.Executor
 	renderer *render.Renderer
+	routes   *routeTable
 	sqlDir   string
 	logger   *slog.Logger
+
+	uploadDir     string
+	maxUploadSize int64
+	queryTimeout  time.Duration
 }
 
 // Config holds server configuration.
@@ -35,6 +42,18 @@ type Config struct {
 	Renderer *render.Renderer
 	SQLDir   string
 	Logger   *slog.Logger
+
+	// UploadDir is where files uploaded to type=file params are saved and
+	// served from (/uploads/). Uploads are only bound as BLOBs when empty.
+	UploadDir string
+
+	// MaxUploadSize limits multipart request bodies (DefaultMaxUploadSize if 0)
+	MaxUploadSize int64
+
+	// QueryTimeout bounds the execution of pages without a -- @page
+	// timeout=, waiting for the writer included (DefaultQueryTimeout if 0,
+	// none if negative). Downloads are not bound by it.
+	QueryTimeout time.Duration
 }
 
 // New creates a new server.
@@ -42,16 +61,37 @@ func New(cfg Config) *Server {
 	if cfg.Logger == nil {
 		cfg.Logger = slog.Default()
 	}
+	if cfg.MaxUploadSize == 0 {
+		cfg.MaxUploadSize = DefaultMaxUploadSize
+	}
+	if cfg.QueryTimeout == 0 {
+		cfg.QueryTimeout = DefaultQueryTimeout
+	}
 
 	s := &Server{
 		router:   chi.NewRouter(),
 		db:       cfg.DB,
 		parser:   engine.NewParser(),
+		pages:    newPageCache(),
 		executor: engine.NewExecutor(),
 		renderer: cfg.Renderer,
 		sqlDir:   cfg.SQLDir,
 		logger:   cfg.Logger,
+
+		uploadDir:     cfg.UploadDir,
+		maxUploadSize: cfg.MaxUploadSize,
+		queryTimeout:  max(cfg.QueryTimeout, 0),
+	}
+
+	routes, err := buildRoutes(cfg.SQLDir)
+	if err != nil {
+		s.logger.Warn("build dynamic routes", "sql_dir", cfg.SQLDir, "error", err)
+		routes = &routeTable{}
 	}
+	s.routes = routes
+
+	// Independent read queries of a page run in parallel on free readers
+	s.executor.Readers = cfg.DB.TryReader
 
 	s.setupRoutes()
 	return s
@@ -71,6 +111,11 @@ func (s *Server) setupRoutes() {
 	// Static assets
 	r.Handle("/assets/*", http.StripPrefix("/assets/", http.FileServer(http.Dir("assets"))))
 
+	// Uploaded files
+	if s.uploadDir != "" {
+		r.Handle("/uploads/*", serveUploads(s.uploadDir))
+	}
+
 	// Health check
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("OK"))
@@ -95,195 +140,320 @@ func (s *Server) handlePage(w http.ResponseWriter, r *http.Request) {
 		path = "/index"
 	}
 	path = strings.TrimSuffix(path, "/")
+	path = strings.TrimSuffix(path, ".json")
 	path = strings.TrimSuffix(path, ".sql")
 
-	// Find SQL file
-	sqlPath := filepath.Join(s.sqlDir, path+".sql")
-	if _, err := os.Stat(sqlPath); os.IsNotExist(err) {
+	// Method handlers (users.get.sql) are only reachable through dispatch,
+	// and files or directories starting with "_" (guards, partials) are private
+	if methodSuffix(path) != "" || strings.Contains(path+"/", "/_") {
 		s.renderError(w, r, http.StatusNotFound, "Page not found")
 		return
 	}
 
-	// Parse SQL file
-	file, err := s.parser.ParseFile(sqlPath)
+	// Find SQL handler: static files win, then dynamic [name].sql routes
+	base := filepath.Join(s.sqlDir, path)
+	var pathParams map[string]string
+	if !handlerExists(base) {
+		file, params, ok := s.routes.match(path)
+		if !ok {
+			s.renderError(w, r, http.StatusNotFound, "Page not found")
+			return
+		}
+		base = filepath.Join(s.sqlDir, file)
+		pathParams = params
+	}
+
+	sqlPath, allow := resolveHandler(base, r.Method)
+	if sqlPath == "" {
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		s.renderError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Parse SQL file (cached until it changes)
+	file, err := s.parse(sqlPath)
 	if err != nil {
 		s.logger.Error("parse error", "path", sqlPath, "error", err)
 		s.renderError(w, r, http.StatusInternalServerError, "Failed to parse SQL file")
 		return
 	}
 
-	// Build params from URL query and form
-	params := make(engine.Params)
-	for key, values := range r.URL.Query() {
-		if len(values) > 0 {
-			params[key] = values[0]
-		}
-	}
-
-	// Parse form for POST requests
-	if r.Method == http.MethodPost {
-		if err := r.ParseForm(); err == nil {
-			for key, values := range r.Form {
-				if len(values) > 0 {
-					params[key] = values[0]
-				}
-			}
-		}
+	// Parse the _guard.sql files protecting the page's directory
+	guards, err := s.parseGuards(sqlPath)
+	if err != nil {
+		s.logger.Error("parse guard error", "path", sqlPath, "error", err)
+		s.renderError(w, r, http.StatusInternalServerError, "Failed to parse SQL file")
+		return
 	}
 
-	// Get appropriate connection
-	var conn *sqlite.Conn
-	var release func()
-
-	if r.Method == http.MethodPost {
-		c, rel, err := s.db.Writer(ctx)
+	// Classify queries on a reader: pages that write (e.g. a view counter
+	// on GET) run on the writer inside a transaction whatever the method.
+	// Files are only classified once, then cached that way.
+	if files := append(guards, file); unclassified(files) {
+		reader, releaseReader, err := s.db.Reader(ctx)
 		if err != nil {
-			s.logger.Error("get writer", "error", err)
+			s.logger.Error("get reader", "error", err)
 			s.renderError(w, r, http.StatusServiceUnavailable, "Database unavailable")
 			return
 		}
-		conn = c
-		release = rel
-	} else {
-		c, rel, err := s.db.Reader(ctx)
+		files, err = s.classify(reader, files)
+		releaseReader()
 		if err != nil {
-			s.logger.Error("get reader", "error", err)
-			s.renderError(w, r, http.StatusServiceUnavailable, "Database unavailable")
+			s.logger.Error("classify error", "path", sqlPath, "error", err)
+			s.renderError(w, r, http.StatusInternalServerError, err.Error())
 			return
 		}
-		conn = c
-		release = rel
+		guards, file = files[:len(guards)], files[len(guards)]
 	}
-	defer release()
 
-	// For POST requests, wrap execution in a transaction to ensure atomicity
-	// across multiple SQL statements and proper write persistence with connection pooling
-	if r.Method == http.MethodPost {
-		beginStmt, _, err := conn.PrepareTransient("BEGIN IMMEDIATE")
-		if err != nil {
-			s.logger.Error("prepare begin", "error", err)
-			s.renderError(w, r, http.StatusInternalServerError, "Database error")
+	// HTMX partial updates only run the queries of the requested fragment
+	file, ok := pageFragment(file, r)
+	if !ok {
+		s.renderError(w, r, http.StatusNotFound, "Fragment not found")
+		return
+	}
+
+	// A download query (or ?_format=) turns the page into a file export
+	exportMatch, exportFormat, err := exportQuery(file, r)
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Build params from the request (query, form, uploads, path, reserved variables)
+	if err := s.parseBody(w, r); err != nil {
+		if errors.Is(err, errUploadTooLarge) {
+			s.renderError(w, r, http.StatusRequestEntityTooLarge, "Upload too large")
 			return
 		}
-		_, err = beginStmt.Step()
-		beginStmt.Finalize()
-		if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, "Invalid form data")
+		return
+	}
+	params := requestParams(r, pathParams)
+	if err := uploadParams(r, file.Params, params); err != nil {
+		s.logger.Error("upload error", "path", sqlPath, "error", err)
+		s.renderError(w, r, http.StatusBadRequest, "Invalid upload")
+		return
+	}
+	isWrite := isWriteMethod(r.Method)
+	for _, f := range append(guards, file) {
+		if f.Writes() {
+			isWrite = true
+		}
+	}
+
+	// From here on, the page is interrupted once its timeout expires,
+	// waiting for the writer included
+	if timeout := s.pageTimeout(file, exportMatch != nil); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Get appropriate connection. It is released before rendering errors,
+	// as custom error pages may need the writer themselves.
+	start := time.Now()
+	conn, release, err := s.conn(ctx, isWrite)
+	s.reportWait(w, isWrite, time.Since(start))
+	if err != nil {
+		if s.interrupted(w, r, ctx, err) {
+			return
+		}
+		s.logger.Error("get connection", "write", isWrite, "error", err)
+		s.renderError(w, r, http.StatusServiceUnavailable, "Database unavailable")
+		return
+	}
+	var releaseOnce sync.Once
+	releaseConn := func() { releaseOnce.Do(release) }
+	defer releaseConn()
+
+	// For write requests, wrap execution in a transaction to ensure atomicity
+	// across multiple SQL statements and proper write persistence with connection pooling
+	if isWrite {
+		if err := execTransient(conn, "BEGIN IMMEDIATE"); err != nil {
 			s.logger.Error("begin transaction", "error", err)
 			// Attempt to rollback any partial transaction state
-			if rollbackStmt, _, _ := conn.PrepareTransient("ROLLBACK"); rollbackStmt != nil {
-				rollbackStmt.Step()
-				rollbackStmt.Finalize()
+			rollback(conn)
+			releaseConn()
+			if s.interrupted(w, r, ctx, err) {
+				return
 			}
 			s.renderError(w, r, http.StatusInternalServerError, "Database error")
 			return
 		}
 	}
 
-	// Execute queries
-	results, err := s.executor.ExecuteFile(ctx, conn, file, params)
-	if err != nil {
-		// Rollback on error for POST requests
-		if r.Method == http.MethodPost {
-			if rollbackStmt, _, _ := conn.PrepareTransient("ROLLBACK"); rollbackStmt != nil {
-				rollbackStmt.Step()
-				rollbackStmt.Finalize()
-			}
+	// Run guards, then the page queries
+	params, denied, err := s.runGuards(ctx, conn, guards, params)
+	if err == nil && denied != "" {
+		if isWrite {
+			rollback(conn)
 		}
-		s.logger.Error("execute error", "error", err)
-		s.renderError(w, r, http.StatusInternalServerError, err.Error())
+		s.redirect(w, r, denied)
 		return
 	}
 
-	// Commit transaction for POST requests
-	if r.Method == http.MethodPost {
-		commitStmt, _, err := conn.PrepareTransient("COMMIT")
+	// Valid uploads are saved once the guards let the request in, so that
+	// the page can bind their path. They are removed again if the page
+	// fails, and those it didn't use once it succeeded. Results are partial
+	// once streamed: the queries rendered or exported on the fly are left
+	// out, so only the uploads no query binds are removed then.
+	var uploads []savedUpload
+	var results []*engine.Result
+	succeeded, partial := false, false
+	defer func() {
+		switch {
+		case !succeeded:
+			removeUploads(uploads)
+		case partial:
+			removeUploads(unboundUploads(uploads, file))
+		default:
+			removeUploads(unusedUploads(uploads, results))
+		}
+	}()
+	if _, verr := engine.ValidateParams(file.Params, params); err == nil && verr == nil {
+		uploads, err = s.saveUploads(file.Params, params)
 		if err != nil {
-			s.logger.Error("prepare commit", "error", err)
-			// Rollback on commit prepare failure
-			if rollbackStmt, _, _ := conn.PrepareTransient("ROLLBACK"); rollbackStmt != nil {
-				rollbackStmt.Step()
-				rollbackStmt.Finalize()
+			if isWrite {
+				rollback(conn)
 			}
-			s.renderError(w, r, http.StatusInternalServerError, "Failed to save changes")
+			releaseConn()
+			s.logger.Error("save upload", "path", sqlPath, "error", err)
+			s.renderError(w, r, http.StatusInternalServerError, "Failed to save upload")
 			return
 		}
-		_, err = commitStmt.Step()
-		commitStmt.Finalize()
-		if err != nil {
+	}
+
+	switch {
+	case err != nil:
+	case exportMatch != nil:
+		// Exports run the page until the exported query, whose rows are
+		// streamed once the writes before it are committed
+		var rw *exportWriter
+		results, rw, err = s.export(ctx, w, conn, file, exportMatch, exportFormat, params, isWrite)
+		if rw != nil && rw.started {
+			// Once rows are on the wire the response can only be cut short
+			if err != nil && r.Context().Err() == nil {
+				s.logger.Error("export error", "path", sqlPath, "error", err)
+			}
+			succeeded, partial = err == nil, true
+			return
+		}
+		if err == nil && rw == nil && exportFormat != "" {
+			err = errNothingExported
+		}
+	case streams(file, r, isWrite):
+		// Streamed pages render each component while its query runs
+		var started bool
+		started, err = s.streamPage(ctx, w, r, conn, file, params)
+		partial = true
+		if started {
+			succeeded = err == nil
+			return
+		}
+	default:
+		results, err = s.executor.ExecuteFile(ctx, conn, file, params)
+	}
+	if err != nil {
+		// Rollback on error for write requests
+		if isWrite {
+			rollback(conn)
+		}
+		releaseConn()
+		var verr *engine.ValidationError
+		if errors.As(err, &verr) {
+			s.renderPageError(w, r, &PageError{
+				Status:  http.StatusBadRequest,
+				Message: "Invalid parameters",
+				Fields:  verr.Fields,
+			})
+			return
+		}
+		var aerr *engine.AuthError
+		if errors.As(err, &aerr) {
+			s.denyAccess(w, r, aerr.Query.Options)
+			return
+		}
+		if errors.Is(err, errNothingExported) {
+			s.renderError(w, r, http.StatusNotFound, "Nothing to export")
+			return
+		}
+		if s.interrupted(w, r, ctx, err) {
+			return
+		}
+		s.logger.Error("execute error", "error", err)
+		s.renderError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Commit transaction for write requests
+	if isWrite {
+		if err := execTransient(conn, "COMMIT"); err != nil {
 			s.logger.Error("commit transaction", "error", err)
 			// Rollback on commit failure
-			if rollbackStmt, _, _ := conn.PrepareTransient("ROLLBACK"); rollbackStmt != nil {
-				rollbackStmt.Step()
-				rollbackStmt.Finalize()
-			}
+			rollback(conn)
+			releaseConn()
 			s.renderError(w, r, http.StatusInternalServerError, "Failed to save changes")
 			return
 		}
 	}
+	succeeded = true
+	releaseConn()
+
+	s.writeResults(w, r, http.StatusOK, results, nil)
+}
 
+// writeResults applies the special components of results to the response
+// and renders the others, as HTML or JSON, with the given status. Forms
+// show fields, the params rejected by validation, next to their inputs.
+func (s *Server) writeResults(w http.ResponseWriter, r *http.Request, status int, results []*engine.Result, fields []engine.FieldError) {
 	// Check for HTMX request
 	isHTMX := r.Header.Get("HX-Request") == "true"
 
-	// Process special components (redirect, refresh, headers)
+	// Dynamic queries pick their components row by row, special ones included
+	results = engine.Expand(results)
+
+	// Process special components (redirect, refresh, headers, cookies).
+	// Redirects are applied last so that cookies and headers set by any
+	// query of the page are part of the redirect response.
 	var filteredResults []*engine.Result
+	var redirect string
 	for _, result := range results {
-		switch result.Query.Component {
-		case "redirect":
-			// Handle redirect
-			target := result.Query.Options["target"]
-			if target == "" && len(result.Rows) > 0 {
-				if t, ok := result.Rows[0]["target"].(string); ok {
-					target = t
-				} else if t, ok := result.Rows[0]["url"].(string); ok {
-					target = t
-				}
-			}
-			if target != "" {
-				if isHTMX {
-					w.Header().Set("HX-Redirect", target)
-					w.WriteHeader(http.StatusOK)
-					return
-				}
-				http.Redirect(w, r, target, http.StatusSeeOther)
-				return
-			}
-
-		case "refresh":
-			// Trigger a page refresh via HTMX
-			if isHTMX {
-				w.Header().Set("HX-Refresh", "true")
-			}
-
-		case "trigger":
-			// Trigger custom HTMX events
-			if event := result.Query.Options["event"]; event != "" {
-				w.Header().Set("HX-Trigger", event)
-			}
-
-		case "header":
-			// Set custom headers
-			for key, val := range result.Query.Options {
-				if key != "component" {
-					w.Header().Set(key, val)
-				}
-			}
-
-		default:
+		special, target := s.special(w, r, result)
+		if !special {
 			filteredResults = append(filteredResults, result)
+		} else if redirect == "" {
+			// The first redirect with a target wins
+			redirect = target
 		}
 	}
 
-	// Build page data with filtered results
+	if redirect != "" {
+		s.redirect(w, r, redirect)
+		return
+	}
+
+	// JSON API mode: same results, without HTML rendering
+	if wantsJSON(r) {
+		s.renderJSON(w, status, filteredResults)
+		return
+	}
+
+	// Build page data with filtered results. Fragments are rendered
+	// without the layout, even when requested outside of HTMX.
 	pageData := &render.PageData{
 		Title:       "GoPage",
 		Results:     filteredResults,
 		CurrentPath: r.URL.Path,
-		IsHTMX:      isHTMX,
+		IsHTMX:      isHTMX || r.URL.Query().Get("_fragment") != "",
+		Fragment:    fragmentID(r),
+		Fields:      fields,
 	}
 
-	// Extract title from shell component if present
+	// The shell result, if any, is passed to the layout with its title
 	for _, result := range filteredResults {
 		if result.Query.Component == "shell" {
+			pageData.Shell = result
 			if title, ok := result.Query.Options["title"]; ok {
 				pageData.Title = title
 			}
@@ -292,26 +462,110 @@ func (s *Server) handlePage(w http.ResponseWriter, r *http.Request) {
 
 	// Render page
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
 	if err := s.renderer.RenderPage(w, pageData); err != nil {
 		s.logger.Error("render error", "error", err)
 	}
 }
 
+// special applies a special component (redirect, refresh, trigger,
+// header, cookie) to the response and reports whether result was one;
+// target is the target of a redirect, left for the caller to send.
+// Authenticate and set results are special too, never rendered.
+func (s *Server) special(w http.ResponseWriter, r *http.Request, result *engine.Result) (special bool, target string) {
+	switch result.Query.Component {
+	case "redirect":
+		return true, redirectTarget(result)
+
+	case "refresh":
+		// Trigger a page refresh via HTMX
+		if r.Header.Get("HX-Request") == "true" {
+			w.Header().Set("HX-Refresh", "true")
+		}
+
+	case "trigger":
+		// Trigger custom HTMX events
+		if event := result.Query.Options["event"]; event != "" {
+			w.Header().Set("HX-Trigger", event)
+		}
+
+	case "header":
+		// Set custom headers
+		for key, val := range result.Query.Options {
+			if key != "component" {
+				w.Header().Set(key, val)
+			}
+		}
+
+	case "cookie":
+		// Set or delete cookies, one per row
+		setCookies(w, r, result)
+
+	case "authenticate", "set":
+		// Consumed by the executor ($_user_* and @set params)
+
+	default:
+		return false, ""
+	}
+	return true, ""
+}
+
+// redirectTarget returns the target of a redirect result: the target
+// option, or the target/url column of the first row.
+func redirectTarget(result *engine.Result) string {
+	target := result.Query.Options["target"]
+	if target == "" && len(result.Rows) > 0 {
+		if t, ok := result.Rows[0]["target"].(string); ok {
+			target = t
+		} else if t, ok := result.Rows[0]["url"].(string); ok {
+			target = t
+		}
+	}
+	return target
+}
+
+// redirect sends a redirect, using HX-Redirect for HTMX requests.
+func (s *Server) redirect(w http.ResponseWriter, r *http.Request, target string) {
+	if r.Header.Get("HX-Request") == "true" && !wantsJSON(r) {
+		w.Header().Set("HX-Redirect", target)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
 // renderError renders an error page.
 func (s *Server) renderError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	s.renderPageError(w, r, &PageError{Status: status, Message: message})
+}
+
+// renderPageError renders an error page for a PageError: a custom SQL
+// error page if one applies, the built-in error template otherwise.
+func (s *Server) renderPageError(w http.ResponseWriter, r *http.Request, pageErr *PageError) {
+	if wantsJSON(r) {
+		s.renderJSONError(w, pageErr)
+		return
+	}
+
+	// _404.sql, _500.sql or _error.sql pages brand errors per section
+	if s.renderCustomError(w, r, pageErr) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(status)
+	w.WriteHeader(pageErr.Status)
 
 	pageData := &render.PageData{
 		Title:       "Error",
 		CurrentPath: r.URL.Path,
 		IsHTMX:      r.Header.Get("HX-Request") == "true",
-		Error:       &PageError{Status: status, Message: message},
+		Error:       pageErr,
+		Fields:      pageErr.Fields,
 	}
 
 	if err := s.renderer.RenderError(w, pageData); err != nil {
 		s.logger.Error("render error page failed", "error", err)
-		http.Error(w, message, status)
+		http.Error(w, pageErr.Message, pageErr.Status)
 	}
 }
 
@@ -319,6 +573,9 @@ func (s *Server) renderError(w http.ResponseWriter, r *http.Request, status int,
 type PageError struct {
 	Status  int
 	Message string
+
+	// Fields lists per-parameter errors for 400 responses
+	Fields []engine.FieldError
 }
 
 func (e *PageError) Error() string {
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/hazyhaar/gopage/pkg/engine"
+	"github.com/hazyhaar/gopage/pkg/render"
+	"zombiezen.com/go/sqlite"
+)
+
+// streams reports whether a page is rendered while its queries run
+// (-- @page stream). Only read-only HTML pages stream: the status and
+// headers are sent before the first query, before a write could fail and
+// be rolled back. Pages stream on request only, so that the others still
+// answer errors with their status and custom error page.
+func streams(file *engine.File, r *http.Request, isWrite bool) bool {
+	return file.Options["stream"] == "true" && !isWrite && !wantsJSON(r)
+}
+
+// streamPage runs a page while writing it: the layout head is flushed
+// before the first query runs (after a leading shell query, whose result
+// the layout gets), then each component as its query runs, rendering the
+// rows of table, list, card, text and alert components as they are
+// stepped so that they are never held in memory. An error returned with
+// started unset, before anything was written (e.g. by a fragment without
+// layout), can still be reported as an error page; once started, it has
+// been logged and rendered in place of the rest of the page.
+func (s *Server) streamPage(ctx context.Context, w http.ResponseWriter, r *http.Request, conn *sqlite.Conn, file *engine.File, params engine.Params) (started bool, err error) {
+	// Invalid params are still reported with a 400 error page
+	if _, err := engine.ValidateParams(file.Params, params); err != nil {
+		return false, err
+	}
+
+	rc := http.NewResponseController(w)
+	flush := func() error {
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
+	}
+	page := s.renderer.NewPageWriter(w, flush, &render.PageData{
+		Title:       "GoPage",
+		CurrentPath: r.URL.Path,
+		IsHTMX:      r.Header.Get("HX-Request") == "true" || r.URL.Query().Get("_fragment") != "",
+		Fragment:    fragmentID(r),
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if len(file.Queries) == 0 || file.Queries[0].Component != "shell" {
+		err = page.Start()
+	}
+
+	if err == nil {
+		err = s.executor.ExecuteStream(ctx, conn, file, params, func(result *engine.Result) error {
+			// Dynamic queries pick their components row by row
+			for _, result := range engine.Expand([]*engine.Result{result}) {
+				if special, _ := s.special(w, r, result); special {
+					// The response headers went with the head
+					if c := result.Query.Component; c != "authenticate" && c != "set" {
+						s.logger.Warn("special component ignored in a streamed page",
+							"component", c, "query", result.Query.Location())
+					}
+					continue
+				}
+				if err := page.Render(result); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, &engine.Export{
+			Match: func(q engine.Query) bool { return s.renderer.Streams(q.Component) },
+			Start: page.Component,
+		})
+	}
+	if err != nil && !page.Started() {
+		return false, err
+	}
+
+	if err != nil {
+		pageErr := &PageError{Status: http.StatusInternalServerError, Message: err.Error()}
+		switch {
+		case r.Context().Err() != nil:
+			s.logger.Info("request canceled", "path", r.URL.Path, "error", err)
+			return true, err
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			s.logger.Warn("page timed out", "path", r.URL.Path, "error", err)
+			pageErr = &PageError{Status: http.StatusGatewayTimeout, Message: "Page timed out"}
+		default:
+			s.logger.Error("execute error", "error", err)
+		}
+		if rerr := page.Error(pageErr); rerr != nil {
+			s.logger.Error("render error", "error", rerr)
+		}
+	}
+	if cerr := page.Close(); cerr != nil {
+		s.logger.Error("render error", "error", cerr)
+		if err == nil {
+			err = cerr
+		}
+	}
+	return true, err
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hazyhaar/gopage/pkg/engine"
+	"zombiezen.com/go/sqlite"
+)
+
+// DefaultQueryTimeout bounds the execution of a page when
+// Config.QueryTimeout is not set.
+const DefaultQueryTimeout = 30 * time.Second
+
+// conn takes the writer connection when write is set, a reader otherwise.
+// The returned function must be called to release it.
+func (s *Server) conn(ctx context.Context, write bool) (*sqlite.Conn, func(), error) {
+	if write {
+		return s.db.Writer(ctx)
+	}
+	return s.db.Reader(ctx)
+}
+
+// reportWait reports the time spent waiting for a connection in the
+// Server-Timing header, as db-wait (readers) or writer-wait.
+func (s *Server) reportWait(w http.ResponseWriter, write bool, wait time.Duration) {
+	name := "db-wait"
+	if write {
+		name = "writer-wait"
+	}
+	w.Header().Add("Server-Timing", fmt.Sprintf("%s;dur=%.3f", name, float64(wait.Microseconds())/1000))
+	s.logger.Debug("connection wait", "write", write, "wait", wait)
+}
+
+// pageTimeout returns the execution time limit of file: its
+// -- @page timeout=, or the server's default. Exports only get the
+// former: streaming a large download legitimately outlasts the default,
+// and the statement is still interrupted when the client goes away.
+// Zero means none.
+func (s *Server) pageTimeout(file *engine.File, export bool) time.Duration {
+	if timeout, ok := file.Timeout(); ok {
+		return timeout
+	}
+	if export {
+		return 0
+	}
+	return s.queryTimeout
+}
+
+// interrupted handles the failure of a page whose context is done: a
+// timeout is reported as 504, a client that went away is only logged.
+// It reports false if ctx is not done, for err to be handled as usual.
+func (s *Server) interrupted(w http.ResponseWriter, r *http.Request, ctx context.Context, err error) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	if r.Context().Err() != nil {
+		s.logger.Info("request canceled", "path", r.URL.Path, "error", err)
+		return true
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		s.logger.Warn("page timed out", "path", r.URL.Path, "error", err)
+		s.renderError(w, r, http.StatusGatewayTimeout, "Page timed out")
+		return true
+	}
+	return false
+}
+
+// execTransient runs a single statement that returns no rows, such as
+// BEGIN IMMEDIATE or COMMIT.
+func execTransient(conn *sqlite.Conn, sql string) error {
+	stmt, _, err := conn.PrepareTransient(sql)
+	if err != nil {
+		return err
+	}
+	defer stmt.Finalize()
+	_, err = stmt.Step()
+	return err
+}
+
+// rollback aborts the current transaction, ignoring errors since it is
+// only called on paths that already failed. It also runs when the
+// connection was interrupted.
+func rollback(conn *sqlite.Conn) {
+	defer conn.SetInterrupt(conn.SetInterrupt(nil))
+	execTransient(conn, "ROLLBACK")
+}
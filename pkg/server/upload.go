@@ -0,0 +1,235 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hazyhaar/gopage/pkg/engine"
+)
+
+// DefaultMaxUploadSize is the request body limit for multipart forms
+// when Config.MaxUploadSize is not set.
+const DefaultMaxUploadSize = 10 << 20
+
+// maxUploadMemory is the part of a multipart body kept in memory while
+// parsing; larger files are spooled to temporary files.
+const maxUploadMemory = 8 << 20
+
+// errUploadTooLarge is returned by parseBody when the body exceeds the limit.
+var errUploadTooLarge = errors.New("upload too large")
+
+// parseBody parses multipart form bodies so that requestParams and
+// uploadParams can read them. Other bodies are left to requestParams.
+func (s *Server) parseBody(w http.ResponseWriter, r *http.Request) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return nil
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return errUploadTooLarge
+		}
+		return err
+	}
+	return nil
+}
+
+// uploadParams binds the files uploaded for the file parameters declared
+// with -- @param name type=file. For each one, $name holds the content
+// (bound as a BLOB) and $name_name, $name_size and $name_mime describe it.
+// The MIME type is sniffed from the content, whatever the client declared,
+// so that accept can't be bypassed. Form values using these names are
+// dropped so they cannot be spoofed. Undeclared file fields are ignored.
+func uploadParams(r *http.Request, specs []engine.ParamSpec, params engine.Params) error {
+	for _, spec := range specs {
+		if spec.Type != engine.ParamFile {
+			continue
+		}
+		for _, suffix := range []string{"", "_name", "_size", "_mime", "_path"} {
+			delete(params, spec.Name+suffix)
+		}
+
+		if r.MultipartForm == nil || len(r.MultipartForm.File[spec.Name]) == 0 {
+			continue
+		}
+		header := r.MultipartForm.File[spec.Name][0]
+
+		// Browsers send an empty part when no file was chosen
+		if header.Filename == "" && header.Size == 0 {
+			continue
+		}
+
+		f, err := header.Open()
+		if err != nil {
+			return fmt.Errorf("open upload %s: %w", spec.Name, err)
+		}
+		content, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("read upload %s: %w", spec.Name, err)
+		}
+
+		mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(content))
+
+		params[spec.Name] = string(content)
+		params[spec.Name+"_name"] = filepath.Base(header.Filename)
+		params[spec.Name+"_size"] = strconv.Itoa(len(content))
+		params[spec.Name+"_mime"] = mimeType
+	}
+	return nil
+}
+
+// savedUpload is a file written by saveUploads.
+type savedUpload struct {
+	// param is the param bound to its name, e.g. avatar_path
+	param string
+	path  string
+}
+
+// saveUploads writes the uploaded files of params to the upload directory
+// and binds their file name within it as $name_path. Files are only saved
+// once the params passed validation and the guards let the request in; it
+// returns the files written so that they can be removed if the request
+// fails or doesn't use them.
+func (s *Server) saveUploads(specs []engine.ParamSpec, params engine.Params) ([]savedUpload, error) {
+	if s.uploadDir == "" {
+		return nil, nil
+	}
+
+	var saved []savedUpload
+	for _, spec := range specs {
+		if spec.Type != engine.ParamFile {
+			continue
+		}
+		content, ok := params[spec.Name]
+		if !ok {
+			continue
+		}
+
+		var buf [16]byte
+		if _, err := rand.Read(buf[:]); err != nil {
+			return saved, err
+		}
+		name := hex.EncodeToString(buf[:]) + uploadExt(params[spec.Name+"_mime"])
+
+		path := filepath.Join(s.uploadDir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			return saved, fmt.Errorf("save upload %s: %w", spec.Name, err)
+		}
+		saved = append(saved, savedUpload{param: spec.Name + "_path", path: path})
+		params[spec.Name+"_path"] = name
+	}
+	return saved, nil
+}
+
+// paramRefRegex matches the params a statement binds: $name or :name
+var paramRefRegex = regexp.MustCompile(`[$:](\w+)`)
+
+// binds reports whether query binds param, directly or through the
+// params of the -- @include that inlined it.
+func binds(query engine.Query, param string) bool {
+	for _, v := range query.Overrides {
+		if v == "$"+param {
+			return true
+		}
+	}
+	for _, m := range paramRefRegex.FindAllStringSubmatch(query.SQL, -1) {
+		if m[1] == param {
+			return true
+		}
+	}
+	return false
+}
+
+// unusedUploads returns the uploads that no query of results kept: a file
+// is only kept when a query binding its $name_path returned or changed
+// rows, so that e.g. an UPDATE denied by its WHERE clause leaves no file
+// behind.
+func unusedUploads(uploads []savedUpload, results []*engine.Result) []savedUpload {
+	var unused []savedUpload
+	for _, upload := range uploads {
+		used := false
+		for _, result := range results {
+			if binds(result.Query, upload.param) && (len(result.Rows) > 0 || result.RowsAffected > 0) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			unused = append(unused, upload)
+		}
+	}
+	return unused
+}
+
+// unboundUploads returns the uploads that no query of file binds, for
+// pages whose results don't hold all the queries that ran (streamed
+// components, exports).
+func unboundUploads(uploads []savedUpload, file *engine.File) []savedUpload {
+	var unbound []savedUpload
+	for _, upload := range uploads {
+		used := false
+		for _, q := range file.Queries {
+			if binds(q, upload.param) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			unbound = append(unbound, upload)
+		}
+	}
+	return unbound
+}
+
+// uploadExt returns the file extension for a MIME type. The client's file
+// name is never used, so that an upload can't choose how it is served.
+func uploadExt(mimeType string) string {
+	exts, _ := mime.ExtensionsByType(mimeType)
+	if len(exts) == 0 {
+		return ""
+	}
+	// ExtensionsByType sorts its result; prefer the usual .jpg over .jfif
+	for _, ext := range exts {
+		if ext == ".jpg" {
+			return ext
+		}
+	}
+	return exts[0]
+}
+
+// removeUploads deletes files saved for a request that failed or didn't
+// use them.
+func removeUploads(uploads []savedUpload) {
+	for _, upload := range uploads {
+		os.Remove(upload.path)
+	}
+}
+
+// serveUploads serves the upload directory. Uploaded files are untrusted:
+// they are never sniffed as another type nor allowed to run scripts.
+func serveUploads(dir string) http.Handler {
+	fileServer := http.StripPrefix("/uploads/", http.FileServer(http.Dir(dir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		fileServer.ServeHTTP(w, r)
+	})
+}
@@ -1,5 +1,5 @@
 -- Forum Schema for GoSQLPage
--- Run this once to initialize the forum database
+-- Forum tables (applied at startup by the migrations runner)
 
 -- Users table
 CREATE TABLE IF NOT EXISTS forum_users (
@@ -173,17 +173,3 @@ CREATE VIRTUAL TABLE IF NOT EXISTS forum_search USING fts5(
     content='',
     tokenize='unicode61'
 );
-
--- Insert default categories
-INSERT OR IGNORE INTO forum_categories (id, name, slug, description, icon, sort_order) VALUES
-    (1, 'Annonces', 'annonces', 'Annonces officielles et nouvelles importantes', 'megaphone', 1),
-    (2, 'Discussions', 'discussions', 'Discussions generales', 'chat-bubble-left-right', 2),
-    (3, 'Questions', 'questions', 'Posez vos questions ici', 'question-mark-circle', 3),
-    (4, 'Tutoriels', 'tutoriels', 'Guides et tutoriels de la communaute', 'academic-cap', 4),
-    (5, 'Projets', 'projets', 'Partagez vos projets', 'rocket-launch', 5);
-
--- Insert admin user - DEMO ONLY! DO NOT USE IN PRODUCTION!
--- WARNING: SHA256 with fixed salt is NOT SECURE. Use bcrypt/Argon2 in production.
--- Hash = SHA256('gosqlpage_forum_salt_2024' + 'admin123')
-INSERT OR IGNORE INTO forum_users (id, username, email, password_hash, display_name, role) VALUES
-    (1, 'admin', 'admin@example.com', 'dad083d0123ad8bc781b1b9d0a629434950de39fb8e2ca6402dbeb74ac77ae98', 'Administrateur', 'admin');
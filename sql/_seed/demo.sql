@@ -0,0 +1,29 @@
+-- Demo data for the sample pages and the forum.
+-- Run with -seed sql/_seed/demo.sql after the migrations; inserts are
+-- idempotent, so it can run on every start. Not for production: it
+-- creates an admin account with a known password.
+
+-- Sample users and posts
+INSERT OR IGNORE INTO users (id, name, email, role) VALUES
+    (1, 'Alice', 'alice@example.com', 'admin'),
+    (2, 'Bob', 'bob@example.com', 'user'),
+    (3, 'Charlie', 'charlie@example.com', 'user');
+
+INSERT OR IGNORE INTO posts (id, user_id, title, content) VALUES
+    (1, 1, 'Welcome to GoPage', 'This is the first post on our new platform!'),
+    (2, 2, 'Getting Started', 'Here is how to build your first SQL page...'),
+    (3, 1, 'Advanced Features', 'Let me show you some cool tricks.');
+
+-- Forum categories
+INSERT OR IGNORE INTO forum_categories (id, name, slug, description, icon, sort_order) VALUES
+    (1, 'Annonces', 'annonces', 'Annonces officielles et nouvelles importantes', 'megaphone', 1),
+    (2, 'Discussions', 'discussions', 'Discussions generales', 'chat-bubble-left-right', 2),
+    (3, 'Questions', 'questions', 'Posez vos questions ici', 'question-mark-circle', 3),
+    (4, 'Tutoriels', 'tutoriels', 'Guides et tutoriels de la communaute', 'academic-cap', 4),
+    (5, 'Projets', 'projets', 'Partagez vos projets', 'rocket-launch', 5);
+
+-- Insert admin user - DEMO ONLY! DO NOT USE IN PRODUCTION!
+-- WARNING: SHA256 with fixed salt is NOT SECURE. Use bcrypt/Argon2 in production.
+-- Hash = SHA256('gosqlpage_forum_salt_2024' + 'admin123')
+INSERT OR IGNORE INTO forum_users (id, username, email, password_hash, display_name, role) VALUES
+    (1, 'admin', 'admin@example.com', 'dad083d0123ad8bc781b1b9d0a629434950de39fb8e2ca6402dbeb74ac77ae98', 'Administrateur', 'admin');
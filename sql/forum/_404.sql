@@ -0,0 +1,7 @@
+-- Forum Not Found Page
+-- @query component=shell title="Page introuvable"
+
+-- @query component=text
+SELECT '<h1>Page introuvable</h1>
+ <p>La page <code>' || escape_html($_path) || '</code> n''existe pas ou a ete deplacee.</p>
+ <p><a href="/forum" class="btn">Retour au forum</a></p>' as html;
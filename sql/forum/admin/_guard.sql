@@ -0,0 +1,14 @@
+-- Admin Guard
+-- Runs before every page under /forum/admin
+
+-- Require a logged-in user ($_user_id, $_user_role, ...)
+-- @query component=authenticate redirect="/forum/login"
+SELECT u.id, u.username, u.display_name, u.role
+FROM forum_sessions s
+JOIN forum_users u ON u.id = s.user_id
+WHERE s.id = $_cookie_session_id AND s.expires_at > datetime('now');
+
+-- Only moderators and admins may enter
+-- @query component=redirect
+SELECT '/forum' as target
+WHERE $_user_role NOT IN ('admin', 'moderator');
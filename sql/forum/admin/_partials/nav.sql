@@ -0,0 +1,11 @@
+-- Admin navigation, included by the admin pages:
+--   -- @include _partials/nav.sql title="..." active=dashboard|users|categories|reports|logs
+-- @query component=text
+SELECT '<h1>' || escape_html($title) || '</h1>
+ <nav class="admin-nav">
+     <a href="/forum/admin"' || CASE WHEN $active = 'dashboard' THEN ' class="active"' ELSE '' END || '>Dashboard</a>
+     <a href="/forum/admin/users"' || CASE WHEN $active = 'users' THEN ' class="active"' ELSE '' END || '>Utilisateurs</a>
+     <a href="/forum/admin/categories"' || CASE WHEN $active = 'categories' THEN ' class="active"' ELSE '' END || '>Categories</a>
+     <a href="/forum/admin/reports"' || CASE WHEN $active = 'reports' THEN ' class="active"' ELSE '' END || '>Signalements</a>
+     <a href="/forum/admin/logs"' || CASE WHEN $active = 'logs' THEN ' class="active"' ELSE '' END || '>Logs</a>
+ </nav>' as html;
@@ -1,27 +1,8 @@
 -- Admin Dashboard
 -- @query component=shell title="Administration"
 
--- Check admin access
--- @query component=text
-SELECT CASE
-    WHEN s.user_id IS NULL THEN
-        '<script>window.location.href = "/forum/login";</script>'
-    WHEN u.role NOT IN ('admin', 'moderator') THEN
-        '<div class="alert alert-error">Acces refuse</div>
-         <script>setTimeout(() => window.location.href = "/forum", 2000);</script>'
-    ELSE
-        '<h1>Administration du forum</h1>
-         <nav class="admin-nav">
-             <a href="/forum/admin" class="active">Dashboard</a>
-             <a href="/forum/admin/users">Utilisateurs</a>
-             <a href="/forum/admin/categories">Categories</a>
-             <a href="/forum/admin/reports">Signalements</a>
-             <a href="/forum/admin/logs">Logs</a>
-         </nav>'
-END as html
-FROM (SELECT 1) dummy
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now')
-LEFT JOIN forum_users u ON u.id = s.user_id;
+-- Admin navigation (access is checked by _guard.sql)
+-- @include _partials/nav.sql title="Administration du forum" active=dashboard
 
 -- Stats cards
 -- @query component=card title="Statistiques"
@@ -32,6 +13,29 @@ SELECT
     (SELECT COUNT(*) FROM forum_posts WHERE deleted_at IS NULL) as "Messages",
     (SELECT COUNT(*) FROM forum_users WHERE last_seen_at > datetime('now', '-1 hour')) as "En ligne";
 
+-- Topics waiting for an answer: a warning and their list, only when there are some
+-- @query component=dynamic
+WITH waiting AS (
+    SELECT id, title, created_at FROM forum_topics
+    WHERE reply_count = 0 AND deleted_at IS NULL AND created_at < datetime('now', '-1 day')
+)
+SELECT 'alert' AS component, 'warning' AS type, NULL AS title, NULL AS message, NULL AS "Sujet", NULL AS "Depuis"
+WHERE EXISTS(SELECT 1 FROM waiting)
+UNION ALL
+SELECT NULL, NULL, NULL, (SELECT COUNT(*) FROM waiting) || ' sujet(s) sans reponse depuis plus d''un jour', NULL, NULL
+WHERE EXISTS(SELECT 1 FROM waiting)
+UNION ALL
+SELECT 'table', NULL, 'Sujets sans reponse', NULL, NULL, NULL
+WHERE EXISTS(SELECT 1 FROM waiting)
+UNION ALL
+SELECT * FROM (
+    SELECT NULL, NULL, NULL, NULL,
+        '<a href="/forum/topic?id=' || id || '">' || escape_html(title) || '</a>', time_ago(created_at)
+    FROM waiting
+    ORDER BY created_at
+    LIMIT 10
+);
+
 -- Recent registrations
 -- @query component=table title="Derniers inscrits"
 SELECT
@@ -15,7 +15,7 @@ SELECT CASE
         <h1>Gérer : ' || escape_html(u.display_name) || '</h1>'
 END as html
 FROM forum_users u
-JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now')
+JOIN forum_sessions s ON s.id = $_cookie_session_id AND s.expires_at > datetime('now')
 JOIN forum_users cu ON cu.id = s.user_id
 WHERE u.id = $id;
 
@@ -32,7 +32,7 @@ SELECT
     u.created_at as "Inscrit le",
     COALESCE(u.last_seen_at, 'Jamais') as "Dernière visite"
 FROM forum_users u
-JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now')
+JOIN forum_sessions s ON s.id = $_cookie_session_id AND s.expires_at > datetime('now')
 JOIN forum_users cu ON cu.id = s.user_id
 WHERE u.id = $id AND cu.role = 'admin';
 
@@ -84,7 +84,7 @@ SELECT
     '<a href="/forum/topic?id=' || t.id || '">' || escape_html(t.title) || '</a>' as "Contenu",
     time_ago(t.created_at) as "Date"
 FROM forum_topics t
-JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now')
+JOIN forum_sessions s ON s.id = $_cookie_session_id AND s.expires_at > datetime('now')
 JOIN forum_users cu ON cu.id = s.user_id
 WHERE t.user_id = $id AND cu.role = 'admin'
 UNION ALL
@@ -93,7 +93,7 @@ SELECT
     '<a href="/forum/topic?id=' || p.topic_id || '#post-' || p.id || '">' || escape_html(SUBSTR(p.content, 1, 50)) || '...</a>' as "Contenu",
     time_ago(p.created_at) as "Date"
 FROM forum_posts p
-JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now')
+JOIN forum_sessions s ON s.id = $_cookie_session_id AND s.expires_at > datetime('now')
 JOIN forum_users cu ON cu.id = s.user_id
 WHERE p.user_id = $id AND cu.role = 'admin'
 ORDER BY 3 DESC
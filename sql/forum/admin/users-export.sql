@@ -0,0 +1,13 @@
+-- Admin Users Export (access is checked by _guard.sql)
+-- @query component=download format=csv filename="utilisateurs"
+SELECT
+    u.id,
+    u.username,
+    u.display_name,
+    u.email,
+    u.role,
+    u.post_count,
+    u.last_seen_at,
+    u.created_at
+FROM forum_users u
+ORDER BY u.id;
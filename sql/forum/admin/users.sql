@@ -1,28 +1,15 @@
 -- Admin Users Management
 -- @query component=shell title="Gestion des utilisateurs"
 
--- Check admin access
--- @query component=text
-SELECT CASE
-    WHEN u.role NOT IN ('admin', 'moderator') THEN
-        '<script>window.location.href = "/forum";</script>'
-    ELSE
-        '<h1>Gestion des utilisateurs</h1>
-         <nav class="admin-nav">
-             <a href="/forum/admin">Dashboard</a>
-             <a href="/forum/admin/users" class="active">Utilisateurs</a>
-             <a href="/forum/admin/categories">Categories</a>
-             <a href="/forum/admin/reports">Signalements</a>
-             <a href="/forum/admin/logs">Logs</a>
-         </nav>'
-END as html
-FROM forum_sessions s
-JOIN forum_users u ON u.id = s.user_id
-WHERE s.id = $session_id AND s.expires_at > datetime('now');
+-- Admin navigation (access is checked by _guard.sql)
+-- @include _partials/nav.sql title="Gestion des utilisateurs" active=users
 
 -- Search form
 -- @query component=search action="/forum/admin/users" placeholder="Rechercher un utilisateur..."
 
+-- @query component=text
+SELECT '<a href="/forum/admin/users-export" class="btn btn-sm">Exporter en CSV</a>' as html;
+
 -- Users list
 -- @query component=table title="Utilisateurs"
 SELECT
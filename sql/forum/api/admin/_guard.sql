@@ -0,0 +1,11 @@
+-- Admin API Guard
+-- Runs before every handler under /forum/api/admin
+
+-- Require a logged-in admin
+-- @query component=authenticate status=403 message="Acces refuse"
+SELECT u.id, u.username, u.display_name, u.role
+FROM forum_sessions s
+JOIN forum_users u ON u.id = s.user_id
+WHERE s.id = $_cookie_session_id
+    AND s.expires_at > datetime('now')
+    AND u.role = 'admin';
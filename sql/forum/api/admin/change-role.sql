@@ -1,7 +1,7 @@
 -- Admin Change Role API
 -- Handles POST /forum/api/admin/change-role
 
--- Check admin access and update role
+-- Check admin access
 -- @query component=text
 SELECT CASE
     WHEN cu.role != 'admin' THEN
@@ -19,20 +19,23 @@ SELECT CASE
 END as html
 FROM forum_sessions s
 JOIN forum_users cu ON cu.id = s.user_id
-WHERE s.id = $session_id AND s.expires_at > datetime('now');
+WHERE s.id = $_cookie_session_id AND s.expires_at > datetime('now');
 
 -- Update role
+-- @query component=text
 UPDATE forum_users
 SET role = $role
 WHERE id = $user_id
+    AND $role IN ('member', 'moderator', 'admin', 'banned')
     AND EXISTS(
         SELECT 1 FROM forum_sessions s
         JOIN forum_users cu ON cu.id = s.user_id
-        WHERE s.id = $session_id AND s.expires_at > datetime('now') AND cu.role = 'admin'
+        WHERE s.id = $_cookie_session_id AND s.expires_at > datetime('now') AND cu.role = 'admin'
     )
-    AND $user_id != (SELECT user_id FROM forum_sessions WHERE id = $session_id);
+    AND $user_id != (SELECT user_id FROM forum_sessions WHERE id = $_cookie_session_id);
 
--- Log action
+-- Log action, if the role was updated
+-- @query component=text
 INSERT INTO forum_mod_log (moderator_id, action, target_type, target_id, reason)
 SELECT
     s.user_id,
@@ -42,4 +45,5 @@ SELECT
     NULLIF($reason, '')
 FROM forum_sessions s
 JOIN forum_users cu ON cu.id = s.user_id
-WHERE s.id = $session_id AND s.expires_at > datetime('now') AND cu.role = 'admin';
+WHERE s.id = $_cookie_session_id AND s.expires_at > datetime('now') AND cu.role = 'admin'
+    AND changes() > 0;
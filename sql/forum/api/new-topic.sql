@@ -1,7 +1,30 @@
 -- New Topic API Handler
 -- Handles POST /forum/api/new-topic
 
--- Check auth and create topic
+-- Insert topic
+-- @query component=text
+INSERT INTO forum_topics (category_id, user_id, title, slug, content)
+SELECT
+    $category_id,
+    s.user_id,
+    TRIM($title),
+    lower(replace(replace(replace(TRIM($title), ' ', '-'), '''', ''), '"', '')),
+    TRIM($content)
+FROM forum_sessions s
+WHERE s.id = $_cookie_session_id
+    AND s.expires_at > datetime('now')
+    AND length(TRIM($title)) >= 5
+    AND length(TRIM($content)) >= 20
+    AND EXISTS(SELECT 1 FROM forum_categories WHERE id = $category_id AND is_locked = 0);
+
+-- Update user post count, if the topic was inserted
+-- @query component=text
+UPDATE forum_users
+SET post_count = post_count + 1
+WHERE id = (SELECT user_id FROM forum_sessions WHERE id = $_cookie_session_id)
+    AND changes() > 0;
+
+-- Result message, linking to the new topic
 -- @query component=text
 SELECT CASE
     WHEN s.user_id IS NULL THEN
@@ -19,28 +42,8 @@ SELECT CASE
     ELSE
         '<div class="alert alert-success">Sujet cree ! Redirection...</div>
          <script>setTimeout(() => window.location.href = "/forum/topic?id=' ||
-            (SELECT id FROM forum_topics WHERE user_id = s.user_id ORDER BY created_at DESC LIMIT 1) ||
+            (SELECT id FROM forum_topics WHERE user_id = s.user_id ORDER BY id DESC LIMIT 1) ||
          '", 1500);</script>'
 END as html
 FROM (SELECT 1) dummy
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now');
-
--- Insert topic
-INSERT INTO forum_topics (category_id, user_id, title, slug, content)
-SELECT
-    $category_id,
-    s.user_id,
-    TRIM($title),
-    lower(replace(replace(replace(TRIM($title), ' ', '-'), '''', ''), '"', '')),
-    TRIM($content)
-FROM forum_sessions s
-WHERE s.id = $session_id
-    AND s.expires_at > datetime('now')
-    AND length(TRIM($title)) >= 5
-    AND length(TRIM($content)) >= 20
-    AND EXISTS(SELECT 1 FROM forum_categories WHERE id = $category_id AND is_locked = 0);
-
--- Update user post count
-UPDATE forum_users
-SET post_count = post_count + 1
-WHERE id = (SELECT user_id FROM forum_sessions WHERE id = $session_id);
+LEFT JOIN forum_sessions s ON s.id = $_cookie_session_id AND s.expires_at > datetime('now');
@@ -1,6 +1,9 @@
 -- Profile Update API Handler
 -- Handles POST /forum/api/profile
 
+-- Optional avatar upload, saved to the upload dir ($avatar_path)
+-- @param avatar type=file max_size=1048576 accept="image/png,image/jpeg,image/gif,image/webp"
+
 -- Update profile
 -- @query component=text
 SELECT CASE
@@ -21,15 +24,17 @@ SELECT CASE
          <script>setTimeout(() => window.location.href = "/forum/profile", 1500);</script>'
 END as html
 FROM (SELECT 1) dummy
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now');
+LEFT JOIN forum_sessions s ON s.id = $_cookie_session_id AND s.expires_at > datetime('now');
 
--- Update user
+-- Update user (an uploaded avatar replaces the avatar URL)
+-- @query component=text
 UPDATE forum_users
 SET
     display_name = TRIM($display_name),
     email = $email,
-    avatar_url = NULLIF(TRIM($avatar_url), ''),
+    avatar_url = COALESCE('/uploads/' || $avatar_path, NULLIF(TRIM($avatar_url), '')),
     bio = NULLIF(TRIM($bio), '')
-WHERE id = (SELECT user_id FROM forum_sessions WHERE id = $session_id AND expires_at > datetime('now'))
+WHERE id = (SELECT user_id FROM forum_sessions WHERE id = $_cookie_session_id AND expires_at > datetime('now'))
     AND length(TRIM($display_name)) >= 2
-    AND $email LIKE '%@%.%';
+    AND $email LIKE '%@%.%'
+    AND NOT EXISTS(SELECT 1 FROM forum_users other WHERE other.email = $email AND other.id != forum_users.id);
@@ -1,7 +1,33 @@
 -- Reaction API Handler
 -- Handles POST /forum/api/react
 
--- Toggle reaction (like/unlike)
+-- Remove the reaction if the user already reacted (toggle off)
+-- @query component=text
+DELETE FROM forum_reactions
+WHERE user_id = (SELECT user_id FROM forum_sessions WHERE id = $_cookie_session_id AND expires_at > datetime('now'))
+    AND (($post_id IS NOT NULL AND post_id = $post_id) OR ($topic_id IS NOT NULL AND topic_id = $topic_id))
+    AND reaction_type = COALESCE($type, 'like');
+
+-- Add it otherwise (toggle on); changes() counts the rows just deleted
+-- @query component=text
+INSERT INTO forum_reactions (user_id, post_id, topic_id, reaction_type)
+SELECT
+    s.user_id,
+    NULLIF($post_id, ''),
+    NULLIF($topic_id, ''),
+    COALESCE($type, 'like')
+FROM forum_sessions s
+WHERE s.id = $_cookie_session_id AND s.expires_at > datetime('now')
+    AND changes() = 0
+    AND NOT EXISTS(
+        SELECT 1 FROM forum_reactions r
+        WHERE r.user_id = s.user_id
+            AND (($post_id IS NOT NULL AND r.post_id = $post_id) OR ($topic_id IS NOT NULL AND r.topic_id = $topic_id))
+            AND r.reaction_type = COALESCE($type, 'like')
+    )
+ON CONFLICT DO NOTHING;
+
+-- Button reflecting the new state
 -- @query component=text
 SELECT CASE
     WHEN s.user_id IS NULL THEN
@@ -22,33 +48,4 @@ SELECT CASE
         '</button>'
 END as html
 FROM (SELECT 1) dummy
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now');
-
--- Toggle reaction in database
-INSERT INTO forum_reactions (user_id, post_id, topic_id, reaction_type)
-SELECT
-    s.user_id,
-    NULLIF($post_id, ''),
-    NULLIF($topic_id, ''),
-    COALESCE($type, 'like')
-FROM forum_sessions s
-WHERE s.id = $session_id AND s.expires_at > datetime('now')
-    AND NOT EXISTS(
-        SELECT 1 FROM forum_reactions r
-        WHERE r.user_id = s.user_id
-            AND (($post_id IS NOT NULL AND r.post_id = $post_id) OR ($topic_id IS NOT NULL AND r.topic_id = $topic_id))
-            AND r.reaction_type = COALESCE($type, 'like')
-    )
-ON CONFLICT DO NOTHING;
-
--- Remove if already exists (toggle behavior)
-DELETE FROM forum_reactions
-WHERE user_id = (SELECT user_id FROM forum_sessions WHERE id = $session_id AND expires_at > datetime('now'))
-    AND (($post_id IS NOT NULL AND post_id = $post_id) OR ($topic_id IS NOT NULL AND topic_id = $topic_id))
-    AND reaction_type = COALESCE($type, 'like')
-    AND id NOT IN (
-        SELECT MAX(id) FROM forum_reactions
-        WHERE user_id = (SELECT user_id FROM forum_sessions WHERE id = $session_id)
-            AND (($post_id IS NOT NULL AND post_id = $post_id) OR ($topic_id IS NOT NULL AND topic_id = $topic_id))
-            AND reaction_type = COALESCE($type, 'like')
-    );
+LEFT JOIN forum_sessions s ON s.id = $_cookie_session_id AND s.expires_at > datetime('now');
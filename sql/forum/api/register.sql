@@ -23,6 +23,7 @@ WHERE html IS NOT NULL;
 
 -- Step 2: Attempt INSERT OR IGNORE - handles unique constraints atomically
 -- This prevents race conditions by letting the database handle uniqueness
+-- @query component=text
 INSERT OR IGNORE INTO forum_users (username, email, password_hash, display_name)
 SELECT
     $username,
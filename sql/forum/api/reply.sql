@@ -1,55 +1,48 @@
 -- Reply API Handler
--- Handles POST /forum/api/reply
+-- Handles POST /forum/api/reply from the topic's reply form. Over HTMX the
+-- response is made of out-of-band swaps: the new post is appended to the
+-- replies, the reply counter refreshed and a flash message shown.
 
--- Check auth and create reply
--- @query component=text
-SELECT CASE
-    WHEN s.user_id IS NULL THEN
-        '<div class="alert alert-error">Vous devez etre connecte</div>
-         <script>setTimeout(() => window.location.href = "/forum/login", 2000);</script>'
-    WHEN length(TRIM($content)) < 5 THEN
-        '<div class="alert alert-error">La reponse doit faire au moins 5 caracteres</div>
-         <script>setTimeout(() => window.history.back(), 2000);</script>'
-    WHEN NOT EXISTS(SELECT 1 FROM forum_topics WHERE id = $topic_id AND is_locked = 0 AND deleted_at IS NULL) THEN
-        '<div class="alert alert-error">Sujet invalide ou verrouille</div>
-         <script>setTimeout(() => window.history.back(), 2000);</script>'
-    ELSE
-        '<div class="alert alert-success">Reponse publiee !</div>
-         <script>setTimeout(() => window.location.href = "/forum/topic?id=' || $topic_id || '#post-' ||
-            (SELECT id FROM forum_posts WHERE topic_id = $topic_id ORDER BY created_at DESC LIMIT 1) ||
-         '", 1000);</script>'
-END as html
+-- Current user and validation error, if any ($me_id, $error)
+-- @set
+SELECT
+    s.user_id AS me_id,
+    CASE
+        WHEN s.user_id IS NULL THEN 'Vous devez etre connecte'
+        WHEN length(TRIM($content)) < 5 THEN 'La reponse doit faire au moins 5 caracteres'
+        WHEN NOT EXISTS(SELECT 1 FROM forum_topics WHERE id = $topic_id AND is_locked = 0 AND deleted_at IS NULL) THEN
+            'Sujet invalide ou verrouille'
+    END AS error
 FROM (SELECT 1) dummy
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now');
+LEFT JOIN forum_sessions s ON s.id = $_cookie_session_id AND s.expires_at > datetime('now');
+
+-- @if $error IS NOT NULL
+-- @query component=alert type=error oob=true target="#reply-flash"
+SELECT $error AS message;
+-- @else
 
 -- Insert reply
+-- @query component=text
 INSERT INTO forum_posts (topic_id, user_id, parent_id, content)
-SELECT
-    $topic_id,
-    s.user_id,
-    NULLIF($quote, ''),
-    TRIM($content)
-FROM forum_sessions s
-WHERE s.id = $session_id
-    AND s.expires_at > datetime('now')
-    AND length(TRIM($content)) >= 5
-    AND EXISTS(SELECT 1 FROM forum_topics WHERE id = $topic_id AND is_locked = 0 AND deleted_at IS NULL);
+VALUES ($topic_id, $me_id, NULLIF($quote, ''), TRIM($content));
 
 -- Update topic stats
+-- @query component=text
 UPDATE forum_topics
 SET
     reply_count = reply_count + 1,
     last_reply_at = datetime('now'),
-    last_reply_by = (SELECT user_id FROM forum_sessions WHERE id = $session_id)
-WHERE id = $topic_id
-    AND EXISTS(SELECT 1 FROM forum_sessions WHERE id = $session_id AND expires_at > datetime('now'));
+    last_reply_by = $me_id
+WHERE id = $topic_id;
 
 -- Update user post count
+-- @query component=text
 UPDATE forum_users
 SET post_count = post_count + 1
-WHERE id = (SELECT user_id FROM forum_sessions WHERE id = $session_id);
+WHERE id = $me_id;
 
 -- Create notification for topic author
+-- @query component=text
 INSERT INTO forum_notifications (user_id, type, title, message, link)
 SELECT
     t.user_id,
@@ -58,7 +51,36 @@ SELECT
     u.display_name || ' a repondu a "' || t.title || '"',
     '/forum/topic?id=' || t.id
 FROM forum_topics t
-JOIN forum_sessions s ON s.id = $session_id
-JOIN forum_users u ON u.id = s.user_id
+JOIN forum_users u ON u.id = $me_id
 WHERE t.id = $topic_id
-    AND t.user_id != s.user_id;
+    AND t.user_id != $me_id;
+
+-- New post, appended to the topic's replies
+-- @query component=text oob=beforeend target="#replies"
+SELECT '<article class="post" id="post-' || p.id || '">
+    <aside class="post-author">
+        <img src="' || COALESCE(u.avatar_url, '/assets/default-avatar.png') || '" alt="" class="avatar">
+        <div class="author-name"><a href="/forum/user?id=' || u.id || '">' || escape_html(u.display_name) || '</a></div>
+        <div class="author-role badge-' || u.role || '">' || u.role || '</div>
+        <div class="author-stats">' || u.post_count || ' messages</div>
+    </aside>
+    <div class="post-content">
+        <div class="post-body">' || escape_html(p.content) || '</div>
+        <footer class="post-footer">
+            <span class="post-date">' || time_ago(p.created_at) || '</span>
+        </footer>
+    </div>
+</article>' as html
+FROM forum_posts p
+JOIN forum_users u ON u.id = p.user_id
+WHERE p.id = (SELECT MAX(id) FROM forum_posts WHERE topic_id = $topic_id AND user_id = $me_id);
+
+-- Reply counter
+-- @query component=text oob=true target="#reply-count"
+SELECT '<h3 class="reply-count">' || reply_count || ' réponses</h3>' as html
+FROM forum_topics
+WHERE id = $topic_id;
+
+-- @query component=alert type=success oob=true target="#reply-flash"
+SELECT 'Reponse publiee !' AS message;
+-- @end
@@ -28,7 +28,7 @@ SELECT CASE
         </div>'
 END as html
 FROM forum_categories cat
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now')
+LEFT JOIN forum_sessions s ON s.id = $_cookie_session_id AND s.expires_at > datetime('now')
 WHERE cat.slug = $slug;
 
 -- Pinned topics
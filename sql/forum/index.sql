@@ -23,7 +23,7 @@ SELECT CASE
         </div>'
 END as html
 FROM (SELECT 1) dummy
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now')
+LEFT JOIN forum_sessions s ON s.id = $_cookie_session_id AND s.expires_at > datetime('now')
 LEFT JOIN forum_users u ON u.id = s.user_id;
 
 -- Categories list
@@ -10,7 +10,12 @@ SELECT CASE
     ELSE ''
 END as html
 FROM (SELECT 1) dummy
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now');
+LEFT JOIN forum_sessions s ON s.id = $_cookie_session_id AND s.expires_at > datetime('now');
+
+-- Failed login attempt
+-- @query component=alert type=error
+SELECT 'Identifiants incorrects ou compte suspendu' as message
+WHERE $error IS NOT NULL;
 
 -- Login form
 -- @query component=form action="/forum/api/login" method="POST"
@@ -1,10 +1,16 @@
 -- Notifications Page
 -- @query component=shell title="Notifications"
 
+-- Current user id ($user_id, NULL when logged out)
+-- @set user_id
+SELECT s.user_id
+FROM forum_sessions s
+WHERE s.id = $_cookie_session_id AND s.expires_at > datetime('now');
+
 -- Check if logged in
 -- @query component=text
 SELECT CASE
-    WHEN s.user_id IS NULL THEN
+    WHEN $user_id IS NULL THEN
         '<script>window.location.href = "/forum/login";</script>'
     ELSE
         '<h1>Notifications</h1>
@@ -13,9 +19,7 @@ SELECT CASE
                  <button type="submit" class="btn btn-sm">Tout marquer comme lu</button>
              </form>
          </div>'
-END as html
-FROM (SELECT 1) dummy
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now');
+END as html;
 
 -- Unread notifications
 -- @query component=table title="Non lues"
@@ -25,8 +29,7 @@ SELECT
     time_ago(n.created_at) as "Date",
     '<a href="' || n.link || '" class="btn btn-sm" hx-post="/forum/api/mark-read?id=' || n.id || '">Voir</a>' as ""
 FROM forum_notifications n
-JOIN forum_sessions s ON s.user_id = n.user_id
-WHERE s.id = $session_id AND s.expires_at > datetime('now') AND n.is_read = 0
+WHERE n.user_id = $user_id AND n.is_read = 0
 ORDER BY n.created_at DESC
 LIMIT 20;
 
@@ -37,7 +40,6 @@ SELECT
     COALESCE(n.message, '') as "Details",
     time_ago(n.created_at) as "Date"
 FROM forum_notifications n
-JOIN forum_sessions s ON s.user_id = n.user_id
-WHERE s.id = $session_id AND s.expires_at > datetime('now') AND n.is_read = 1
+WHERE n.user_id = $user_id AND n.is_read = 1
 ORDER BY n.created_at DESC
 LIMIT 50;
@@ -1,16 +1,15 @@
 -- My Profile (Edit)
 -- @query component=shell title="Mon profil"
 
--- Check if logged in
+-- Require a logged-in user ($_user_id, $_user_display_name, ...)
+-- @query component=authenticate redirect="/forum/login"
+SELECT u.id, u.display_name, u.email, u.avatar_url, u.bio
+FROM forum_sessions s
+JOIN forum_users u ON u.id = s.user_id
+WHERE s.id = $_cookie_session_id AND s.expires_at > datetime('now');
+
 -- @query component=text
-SELECT CASE
-    WHEN s.user_id IS NULL THEN
-        '<script>window.location.href = "/forum/login";</script>'
-    ELSE
-        '<h1>Mon profil</h1>'
-END as html
-FROM (SELECT 1) dummy
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now');
+SELECT '<h1>Mon profil</h1>' as html;
 
 -- Profile form
 -- @query component=form action="/forum/api/profile" method="POST"
@@ -18,38 +17,32 @@ SELECT
     'text' as type,
     'display_name' as name,
     'Nom affiche' as label,
-    u.display_name as value,
+    $_user_display_name as value,
     1 as required
-FROM forum_users u
-JOIN forum_sessions s ON s.user_id = u.id
-WHERE s.id = $session_id
 UNION ALL SELECT
     'email' as type,
     'email' as name,
     'Email' as label,
-    u.email as value,
+    $_user_email as value,
     1 as required
-FROM forum_users u
-JOIN forum_sessions s ON s.user_id = u.id
-WHERE s.id = $session_id
 UNION ALL SELECT
     'url' as type,
     'avatar_url' as name,
     'URL de l avatar' as label,
-    COALESCE(u.avatar_url, '') as value,
+    COALESCE($_user_avatar_url, '') as value,
+    0 as required
+UNION ALL SELECT
+    'file' as type,
+    'avatar' as name,
+    'Ou envoyer une image (PNG, JPEG, GIF, WebP - 1 Mo max)' as label,
+    '' as value,
     0 as required
-FROM forum_users u
-JOIN forum_sessions s ON s.user_id = u.id
-WHERE s.id = $session_id
 UNION ALL SELECT
     'textarea' as type,
     'bio' as name,
     'Biographie' as label,
-    COALESCE(u.bio, '') as value,
+    COALESCE($_user_bio, '') as value,
     0 as required
-FROM forum_users u
-JOIN forum_sessions s ON s.user_id = u.id
-WHERE s.id = $session_id
 UNION ALL SELECT
     'submit' as type,
     'submit' as name,
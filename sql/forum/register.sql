@@ -10,7 +10,7 @@ SELECT CASE
     ELSE ''
 END as html
 FROM (SELECT 1) dummy
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now');
+LEFT JOIN forum_sessions s ON s.id = $_cookie_session_id AND s.expires_at > datetime('now');
 
 -- Registration form
 -- @query component=form action="/forum/api/register" method="POST"
@@ -4,15 +4,11 @@
 -- Search form
 -- @query component=search action="/forum/search" placeholder="Rechercher dans le forum..."
 
--- Show query if provided
+-- Results only when a search term is provided
+-- @if $q IS NOT NULL AND $q != ''
+
 -- @query component=text
-SELECT CASE
-    WHEN $q IS NOT NULL AND $q != '' THEN
-        '<h2>Resultats pour "' || $q || '"</h2>'
-    ELSE
-        '<h2>Rechercher</h2>
-         <p>Entrez un terme de recherche pour trouver des sujets et messages.</p>'
-END as html;
+SELECT '<h2>Resultats pour "' || escape_html($q) || '"</h2>' as html;
 
 -- Search in topics
 -- @query component=table title="Sujets"
@@ -26,7 +22,6 @@ FROM forum_topics t
 JOIN forum_categories c ON c.id = t.category_id
 JOIN forum_users u ON u.id = t.user_id
 WHERE t.deleted_at IS NULL
-    AND $q IS NOT NULL AND $q != ''
     AND (t.title LIKE '%' || $q || '%' OR t.content LIKE '%' || $q || '%')
 ORDER BY
     CASE WHEN t.title LIKE '%' || $q || '%' THEN 0 ELSE 1 END,
@@ -44,7 +39,6 @@ FROM forum_posts p
 JOIN forum_topics t ON t.id = p.topic_id
 JOIN forum_users u ON u.id = p.user_id
 WHERE p.deleted_at IS NULL AND t.deleted_at IS NULL
-    AND $q IS NOT NULL AND $q != ''
     AND p.content LIKE '%' || $q || '%'
 ORDER BY p.created_at DESC
 LIMIT 20;
@@ -57,7 +51,14 @@ SELECT
     u.post_count || ' messages' as "Activite",
     'Inscrit ' || time_ago(u.created_at) as "Inscription"
 FROM forum_users u
-WHERE $q IS NOT NULL AND $q != ''
-    AND (u.username LIKE '%' || $q || '%' OR u.display_name LIKE '%' || $q || '%')
+WHERE u.username LIKE '%' || $q || '%' OR u.display_name LIKE '%' || $q || '%'
 ORDER BY u.post_count DESC
 LIMIT 10;
+
+-- @else
+
+-- @query component=text
+SELECT '<h2>Rechercher</h2>
+ <p>Entrez un terme de recherche pour trouver des sujets et messages.</p>' as html;
+
+-- @end
@@ -4,6 +4,13 @@
 -- Increment view count
 UPDATE forum_topics SET view_count = view_count + 1 WHERE id = $id;
 
+-- Current user, looked up once for the whole page ($me_id, $me_role; NULL when logged out)
+-- @set me
+SELECT u.id, u.role
+FROM forum_sessions s
+JOIN forum_users u ON u.id = s.user_id
+WHERE s.id = $_cookie_session_id AND s.expires_at > datetime('now');
+
 -- Topic header with breadcrumb
 -- @query component=text
 SELECT '<div class="topic-header">
@@ -28,8 +35,8 @@ JOIN forum_categories c ON c.id = t.category_id
 JOIN forum_users u ON u.id = t.user_id
 WHERE t.id = $id;
 
--- Original post
--- @query component=text
+-- Original post, refreshed on its own (?_fragment=topic-post) after a like
+-- @query component=text id=topic-post
 SELECT '<article class="post post-original" id="post-0">
     <aside class="post-author">
         <img src="' || COALESCE(u.avatar_url, '/assets/default-avatar.png') || '" alt="" class="avatar">
@@ -42,14 +49,15 @@ SELECT '<article class="post post-original" id="post-0">
         <footer class="post-footer">
             <span class="post-date">' || time_ago(t.created_at) || '</span>
             <div class="post-actions">
-                ' || CASE WHEN s.user_id IS NOT NULL THEN
-                    '<button class="btn btn-sm" hx-post="/forum/api/react?topic_id=' || t.id || '&type=like" hx-swap="outerHTML">
+                ' || CASE WHEN $me_id IS NOT NULL THEN
+                    '<button class="btn btn-sm" hx-post="/forum/api/react?topic_id=' || t.id || '&type=like" hx-swap="none"
+                        hx-on::after-request="htmx.ajax(''GET'', ''/forum/topic?id=' || t.id || ''', ''#topic-post'')">
                         <span class="icon">+</span> ' ||
-                        (SELECT COUNT(*) FROM forum_reactions r WHERE r.topic_id = t.id AND r.reaction_type = ''like'') ||
+                        (SELECT COUNT(*) FROM forum_reactions r WHERE r.topic_id = t.id AND r.reaction_type = 'like') ||
                     '</button>
                     <a href="/forum/reply?topic=' || t.id || '" class="btn btn-sm">Répondre</a>'
                 ELSE '' END || '
-                ' || CASE WHEN s.user_id = t.user_id OR cu.role IN (''admin'', ''moderator'') THEN
+                ' || CASE WHEN $me_id = t.user_id OR $me_role IN ('admin', 'moderator') THEN
                     '<a href="/forum/edit-topic?id=' || t.id || '" class="btn btn-sm">Modifier</a>'
                 ELSE '' END || '
             </div>
@@ -58,12 +66,16 @@ SELECT '<article class="post post-original" id="post-0">
 </article>' as html
 FROM forum_topics t
 JOIN forum_users u ON u.id = t.user_id
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now')
-LEFT JOIN forum_users cu ON cu.id = s.user_id
 WHERE t.id = $id;
 
--- Replies
--- @query component=text
+-- Reply counter, refreshed out of band by api/reply.sql
+-- @query component=text id=reply-count
+SELECT '<h3 class="reply-count">' || reply_count || ' réponses</h3>' as html
+FROM forum_topics
+WHERE id = $id;
+
+-- Replies (api/reply.sql appends new ones)
+-- @query component=text id=replies
 SELECT '<article class="post' || CASE WHEN p.is_solution THEN ' post-solution' ELSE '' END || '" id="post-' || p.id || '">
     <aside class="post-author">
         <img src="' || COALESCE(u.avatar_url, '/assets/default-avatar.png') || '" alt="" class="avatar">
@@ -83,17 +95,17 @@ SELECT '<article class="post' || CASE WHEN p.is_solution THEN ' post-solution' E
             <span class="post-date">' || time_ago(p.created_at) ||
             CASE WHEN p.edit_count > 0 THEN ' (modifié ' || p.edit_count || ' fois)' ELSE '' END || '</span>
             <div class="post-actions">
-                ' || CASE WHEN s.user_id IS NOT NULL THEN
+                ' || CASE WHEN $me_id IS NOT NULL THEN
                     '<button class="btn btn-sm" hx-post="/forum/api/react?post_id=' || p.id || '&type=like" hx-swap="outerHTML">
                         <span class="icon">+</span> ' ||
-                        (SELECT COUNT(*) FROM forum_reactions r WHERE r.post_id = p.id AND r.reaction_type = ''like'') ||
+                        (SELECT COUNT(*) FROM forum_reactions r WHERE r.post_id = p.id AND r.reaction_type = 'like') ||
                     '</button>
                     <a href="/forum/reply?topic=' || p.topic_id || '&quote=' || p.id || '" class="btn btn-sm">Citer</a>'
                 ELSE '' END || '
-                ' || CASE WHEN s.user_id = p.user_id OR cu.role IN (''admin'', ''moderator'') THEN
+                ' || CASE WHEN $me_id = p.user_id OR $me_role IN ('admin', 'moderator') THEN
                     '<a href="/forum/edit-post?id=' || p.id || '" class="btn btn-sm">Modifier</a>'
                 ELSE '' END || '
-                ' || CASE WHEN (cu.role IN (''admin'', ''moderator'') OR s.user_id = t.user_id) AND t.is_solved = 0 THEN
+                ' || CASE WHEN ($me_role IN ('admin', 'moderator') OR $me_id = t.user_id) AND t.is_solved = 0 THEN
                     '<button class="btn btn-sm btn-success" hx-post="/forum/api/mark-solution?post_id=' || p.id || '">Marquer comme solution</button>'
                 ELSE '' END || '
             </div>
@@ -103,8 +115,6 @@ SELECT '<article class="post' || CASE WHEN p.is_solution THEN ' post-solution' E
 FROM forum_posts p
 JOIN forum_users u ON u.id = p.user_id
 JOIN forum_topics t ON t.id = p.topic_id
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now')
-LEFT JOIN forum_users cu ON cu.id = s.user_id
 WHERE p.topic_id = $id AND p.deleted_at IS NULL
 ORDER BY p.created_at;
 
@@ -113,14 +123,16 @@ ORDER BY p.created_at;
 SELECT CASE
     WHEN t.is_locked = 1 THEN
         '<div class="alert alert-info">Ce sujet est verrouillé, vous ne pouvez pas répondre.</div>'
-    WHEN s.user_id IS NULL THEN
+    WHEN $me_id IS NULL THEN
         '<div class="alert alert-info">
             <a href="/forum/login">Connectez-vous</a> pour répondre à ce sujet.
         </div>'
     ELSE
         '<div class="reply-form">
             <h3>Répondre</h3>
-            <form action="/forum/api/reply" method="POST">
+            <div id="reply-flash"></div>
+            <form action="/forum/api/reply" method="POST" hx-post="/forum/api/reply" hx-swap="none"
+                  hx-on::after-request="if (event.detail.successful) this.reset()">
                 <input type="hidden" name="topic_id" value="' || t.id || '">
                 <textarea name="content" rows="6" placeholder="Votre réponse..." required></textarea>
                 <button type="submit" class="btn btn-primary">Publier la réponse</button>
@@ -128,5 +140,4 @@ SELECT CASE
         </div>'
 END as html
 FROM forum_topics t
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now')
 WHERE t.id = $id;
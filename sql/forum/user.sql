@@ -62,5 +62,5 @@ SELECT CASE
     ELSE ''
 END as html
 FROM (SELECT 1) dummy
-LEFT JOIN forum_sessions s ON s.id = $session_id AND s.expires_at > datetime('now')
+LEFT JOIN forum_sessions s ON s.id = $_cookie_session_id AND s.expires_at > datetime('now')
 LEFT JOIN forum_users cu ON cu.id = s.user_id;
@@ -2,9 +2,11 @@
 -- Handles POST to delete a user
 
 -- Delete the user
+-- @query component=text
 DELETE FROM users WHERE id = $id;
 
 -- Also delete their posts
+-- @query component=text
 DELETE FROM posts WHERE user_id = $id;
 
 -- Redirect back to users list